@@ -0,0 +1,119 @@
+// Package analyzer exposes tracegen as a go/analysis Analyzer, so that
+// functions with missing or stale instrumentation can be reported, and fixed,
+// by go vet, singlechecker, golangci-lint and gopls.
+package analyzer
+
+import (
+	"path/filepath"
+
+	"github.com/Deiz/tracegen"
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver"
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/packages"
+)
+
+const Doc = `report functions whose tracing instrumentation is missing or stale
+
+The tracegen analyzer reports functions whose instrumentation is missing, out
+of date, or present despite the function being skipped, using the same
+decisions as the tracegen command. Each diagnostic carries a suggested fix that
+applies the updater to the function.`
+
+// Config holds what's needed to decide on and apply fixes.
+type Config struct {
+	Settings    tracegen.Settings
+	Updater     tracegen.Updater
+	Inspect     tracegen.Inspector
+	GetResolver func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver
+}
+
+// New returns an analyzer using cfg.
+func New(cfg Config) *analysis.Analyzer {
+	return &analysis.Analyzer{
+		Name: "tracegen",
+		Doc:  Doc,
+		Run: func(pass *analysis.Pass) (interface{}, error) {
+			return nil, Run(pass, cfg)
+		},
+	}
+}
+
+// Run reports the problems found in pass's package, for analyzers that need to
+// build cfg at run time, e.g. from their flags.
+func Run(pass *analysis.Pass, cfg Config) error {
+	pkg, err := decorate(pass)
+	if err != nil {
+		return err
+	}
+
+	fixes, err := tracegen.Fixes(cfg.Settings, pkg, cfg.Updater, cfg.Inspect, cfg.GetResolver)
+	if err != nil {
+		return err
+	}
+
+	for _, fix := range fixes {
+		var edits []analysis.TextEdit
+		for _, e := range fix.Edits {
+			edits = append(edits, analysis.TextEdit{Pos: e.Pos, End: e.End, NewText: e.NewText})
+		}
+
+		pass.Report(analysis.Diagnostic{
+			Pos:      fix.Pos,
+			Category: fix.Rule,
+			Message:  fix.Message,
+			SuggestedFixes: []analysis.SuggestedFix{
+				{Message: fixMessages[fix.Rule], TextEdits: edits},
+			},
+		})
+	}
+
+	return nil
+}
+
+var fixMessages = map[string]string{
+	tracegen.RuleMissing: "Add instrumentation",
+	tracegen.RuleStale:   "Update instrumentation",
+	tracegen.RuleSkipped: "Remove instrumentation",
+}
+
+// decorate converts pass's package into the form used by tracegen.
+func decorate(pass *analysis.Pass) (*decorator.Package, error) {
+	pkg := &decorator.Package{
+		Package: &packages.Package{
+			ID:        pass.Pkg.Path(),
+			Name:      pass.Pkg.Name(),
+			PkgPath:   pass.Pkg.Path(),
+			Fset:      pass.Fset,
+			Syntax:    pass.Files,
+			Types:     pass.Pkg,
+			TypesInfo: pass.TypesInfo,
+		},
+		Imports: make(map[string]*decorator.Package),
+	}
+
+	for _, imp := range pass.Pkg.Imports() {
+		pkg.Imports[imp.Path()] = &decorator.Package{
+			Package: &packages.Package{ID: imp.Path(), Name: imp.Name(), PkgPath: imp.Path()},
+		}
+	}
+
+	pkg.Decorator = decorator.NewDecoratorFromPackage(pkg.Package)
+
+	for _, f := range pass.Files {
+		file, err := pkg.Decorator.DecorateFile(f)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decorate file")
+		}
+
+		pkg.Syntax = append(pkg.Syntax, file)
+	}
+
+	if len(pass.Files) > 0 {
+		pkg.Dir = filepath.Dir(pass.Fset.File(pass.Files[0].Pos()).Name())
+	}
+
+	return pkg, nil
+}
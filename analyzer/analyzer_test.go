@@ -0,0 +1,98 @@
+package analyzer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Deiz/tracegen"
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/packages"
+)
+
+const template = `package template
+
+func _(__ctx__ int) {
+	println(__ctx__, "__span__")
+}
+`
+
+const input = `package main
+
+func Foo(ctx int) {}
+
+func Bar(ctx int) {
+	println(ctx, "Bar")
+}
+`
+
+func TestAnalyzer(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	check(t, err)
+
+	err = os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module test\n\ngo 1.17\n"), 0644)
+	check(t, err)
+
+	err = os.WriteFile(filepath.Join(dir, "main.go"), []byte(input), 0644)
+	check(t, err)
+
+	update, err := tracegen.NewTemplateUpdater([]byte(template))
+	check(t, err)
+
+	a := New(Config{
+		Updater: update,
+		Inspect: update.Inspect,
+		GetResolver: func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+			return tracegen.NewSimpleResolver(pkg, file, nil)
+		},
+	})
+
+	cfg := &packages.Config{Mode: packages.LoadSyntax, Dir: dir}
+	pkgs, err := packages.Load(cfg, ".")
+	check(t, err)
+
+	var diagnostics []analysis.Diagnostic
+
+	pass := &analysis.Pass{
+		Analyzer:  a,
+		Fset:      pkgs[0].Fset,
+		Files:     pkgs[0].Syntax,
+		Pkg:       pkgs[0].Types,
+		TypesInfo: pkgs[0].TypesInfo,
+		Report: func(d analysis.Diagnostic) {
+			diagnostics = append(diagnostics, d)
+		},
+	}
+
+	_, err = a.Run(pass)
+	check(t, err)
+
+	if len(diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %d", len(diagnostics))
+	}
+
+	d := diagnostics[0]
+	if d.Category != tracegen.RuleMissing || pass.Fset.Position(d.Pos).Line != 3 {
+		t.Fatalf("expected missing instrumentation on line 3, got %s at %s", d.Category, pass.Fset.Position(d.Pos))
+	}
+
+	if len(d.SuggestedFixes) != 1 || len(d.SuggestedFixes[0].TextEdits) != 1 {
+		t.Fatalf("expected a single suggested edit, got %+v", d.SuggestedFixes)
+	}
+
+	expected := "func Foo(ctx int) {\n\tprintln(ctx, \"Foo\")\n}"
+	if text := string(d.SuggestedFixes[0].TextEdits[0].NewText); text != expected {
+		t.Fatalf("mismatched edit:\ngot:\n%s\nexpected:\n%s", text, expected)
+	}
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,185 @@
+package tracegen
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	gopackages "golang.org/x/tools/go/packages"
+)
+
+// modulePath is tracegen's own module path, used to find its version.
+const modulePath = "github.com/Deiz/tracegen"
+
+// A Cache records packages that have already converged, i.e. that the updater
+// left unchanged, so that later runs can skip them without decorating them.
+// Entries are keyed by the contents of a package's files, the names of the
+// packages it imports, the settings, the cache's Key and the version of
+// tracegen, so a change to any of these invalidates them.
+type Cache struct {
+	// Dir is the directory entries are stored in.
+	Dir string
+
+	// Key identifies the updater, along with anything else that affects its
+	// output, such as a template's contents.
+	Key string
+}
+
+// DefaultCacheDir returns tracegen's directory within the user's cache
+// directory.
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to find cache directory")
+	}
+
+	return filepath.Join(dir, "tracegen"), nil
+}
+
+// key returns the cache key for pkg, whose files are read from fs.
+func (c *Cache) key(settings Settings, fs FS, pkg *gopackages.Package) (string, error) {
+	h := sha256.New()
+
+	fmt.Fprintf(h, "tracegen %s %s\n", version(), runtime.Version())
+	fmt.Fprintf(h, "key %q\n", c.Key)
+	fmt.Fprintf(h, "settings %q %t %t %t\n", settings.Exclude, settings.Tagged, settings.Exported, settings.Methods)
+	fmt.Fprintf(h, "package %s\n", pkg.PkgPath)
+
+	imports := make([]string, 0, len(pkg.Imports))
+	for path := range pkg.Imports {
+		imports = append(imports, path)
+	}
+
+	sort.Strings(imports)
+
+	for _, path := range imports {
+		fmt.Fprintf(h, "import %s %s\n", path, pkg.Imports[path].Name)
+	}
+
+	filenames := append([]string(nil), pkg.GoFiles...)
+	sort.Strings(filenames)
+
+	for _, filename := range filenames {
+		data, err := fs.ReadFile(filename)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to read file %s", filename)
+		}
+
+		fmt.Fprintf(h, "file %s %d\n", filename, len(data))
+		h.Write(data)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.Dir, key[:2], key)
+}
+
+// converged reports whether the package with the given key has converged.
+func (c *Cache) converged(key string) bool {
+	_, err := os.Stat(c.path(key))
+	return err == nil
+}
+
+// markConverged records that the package with the given key has converged.
+func (c *Cache) markConverged(key string) error {
+	path := c.path(key)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return errors.Wrap(err, "failed to create cache directory")
+	}
+
+	if err := os.WriteFile(path, nil, 0666); err != nil {
+		return errors.Wrap(err, "failed to write cache entry")
+	}
+
+	return nil
+}
+
+var (
+	versionOnce sync.Once
+	versionID   string
+)
+
+// version identifies the running build of tracegen, so that upgrading it
+// invalidates the cache. Development builds without a clean VCS revision are
+// identified by the contents of the executable instead.
+func version() string {
+	versionOnce.Do(func() {
+		versionID = buildVersion()
+	})
+
+	return versionID
+}
+
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return executableHash()
+	}
+
+	mod := &info.Main
+	if mod.Path != modulePath {
+		for _, dep := range info.Deps {
+			if dep.Path == modulePath {
+				mod = dep
+				break
+			}
+		}
+	}
+
+	if mod.Replace != nil {
+		mod = mod.Replace
+	}
+
+	if mod.Path == modulePath && mod.Version != "" && mod.Version != "(devel)" {
+		return mod.Version + " " + mod.Sum
+	}
+
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value
+		}
+	}
+
+	if mod == &info.Main && revision != "" && modified == "false" {
+		return revision
+	}
+
+	return executableHash()
+}
+
+// executableHash returns a hash of the running executable, or an empty string
+// if it can't be read.
+func executableHash() string {
+	filename, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,90 @@
+package tracegen
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver"
+)
+
+func TestProcessorCache(t *testing.T) {
+	path := writeModule(t, compositeInput)
+
+	cacheDir, err := os.MkdirTemp("", "")
+	check(t, err)
+
+	var calls int
+
+	p := &Processor{
+		Updater: countingUpdater{Updater: printlnUpdater{name: "first"}, calls: &calls},
+		GetResolver: func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+			return NewSimpleResolver(pkg, file, nil)
+		},
+		Dir:   filepath.Dir(path),
+		Cache: &Cache{Dir: cacheDir, Key: "test"},
+	}
+
+	// The first run changes the file, and the second finds it has converged
+	runs := []struct {
+		calls  int
+		cached bool
+	}{
+		{1, false},
+		{1, false},
+		{0, true},
+	}
+
+	for i, run := range runs {
+		calls = 0
+
+		result, err := p.Process([]string{"."})
+		check(t, err)
+
+		if calls != run.calls {
+			t.Fatalf("expected %d updater calls on run %d, got %d", run.calls, i+1, calls)
+		}
+
+		if cached := result.Packages[0].Cached; cached != run.cached {
+			t.Fatalf("expected cached to be %t on run %d", run.cached, i+1)
+		}
+	}
+
+	// Editing the file invalidates the entry
+	data, err := os.ReadFile(path)
+	check(t, err)
+	check(t, os.WriteFile(path, append(data, "\nfunc Bar() {}\n"...), 0644))
+
+	calls = 0
+
+	result, err := p.Process([]string{"."})
+	check(t, err)
+
+	if calls != 2 || result.Packages[0].Cached {
+		t.Fatalf("expected the edited package to be processed, got %d calls", calls)
+	}
+
+	// As does changing the key
+	p.Cache.Key = "other"
+	calls = 0
+
+	_, err = p.Process([]string{"."})
+	check(t, err)
+
+	if calls != 2 {
+		t.Fatalf("expected the package to be processed under a new key, got %d calls", calls)
+	}
+}
+
+// countingUpdater counts the calls made to its updater.
+type countingUpdater struct {
+	Updater
+	calls *int
+}
+
+func (u countingUpdater) Update(fc *FuncContext) (imports []string, err error) {
+	*u.calls++
+	return u.Updater.Update(fc)
+}
@@ -0,0 +1,161 @@
+package tracegen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// Catalog lists every span managed by tracegen, e.g. for generating dashboards
+// or diffing between releases.
+type Catalog struct {
+	Spans []CatalogEntry `json:"spans"`
+}
+
+// CatalogEntry describes a single span and the function it belongs to. File is
+// the package path joined with the file name, so entries remain stable
+// regardless of where the code is checked out.
+type CatalogEntry struct {
+	Span       string   `json:"span"`
+	Function   string   `json:"function"`
+	Receiver   string   `json:"receiver,omitempty"`
+	Package    string   `json:"package"`
+	File       string   `json:"file"`
+	Line       int      `json:"line"`
+	Directives []string `json:"directives,omitempty"`
+
+	filename string
+	column   int
+}
+
+// A Duplicate is a span name shared by more than one function. Scope is
+// "package" if all of the functions are within the same package, and
+// "module" otherwise.
+type Duplicate struct {
+	Span    string         `json:"span"`
+	Scope   string         `json:"scope"`
+	Entries []CatalogEntry `json:"entries"`
+}
+
+// BuildCatalog collects the span names reported by inspect for every eligible
+// function that isn't skipped.
+func BuildCatalog(settings Settings, pkgs []*decorator.Package, inspect Inspector) (c *Catalog, err error) {
+	c = &Catalog{Spans: []CatalogEntry{}}
+
+	for _, pkg := range pkgs {
+		if _, ok := excluded(settings, pkg); ok {
+			continue
+		}
+
+		inspectFuncs(settings, pkg, func(file *dst.File, node *dst.FuncDecl, shouldSkip bool) {
+			state := inspect(node)
+			if !state.Eligible || shouldSkip || state.SpanName == "" {
+				return
+			}
+
+			start, _ := position(pkg, node)
+
+			c.Spans = append(c.Spans, CatalogEntry{
+				Span:       state.SpanName,
+				Function:   node.Name.Name,
+				Receiver:   receiverName(node),
+				Package:    pkg.PkgPath,
+				File:       path.Join(pkg.PkgPath, filepath.Base(start.Filename)),
+				Line:       start.Line,
+				Directives: directives(node.Decs.Start),
+				filename:   start.Filename,
+				column:     start.Column,
+			})
+		})
+	}
+
+	sort.SliceStable(c.Spans, func(i, j int) bool {
+		a, b := c.Spans[i], c.Spans[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+
+	return c, nil
+}
+
+// Duplicates returns every span name in the catalog that is used by more than
+// one function, ordered by span name.
+func (c *Catalog) Duplicates() (duplicates []Duplicate) {
+	bySpan := make(map[string][]CatalogEntry)
+	for _, e := range c.Spans {
+		bySpan[e.Span] = append(bySpan[e.Span], e)
+	}
+
+	for span, entries := range bySpan {
+		if len(entries) < 2 {
+			continue
+		}
+
+		scope := "package"
+		for _, e := range entries[1:] {
+			if e.Package != entries[0].Package {
+				scope = "module"
+				break
+			}
+		}
+
+		duplicates = append(duplicates, Duplicate{Span: span, Scope: scope, Entries: entries})
+	}
+
+	sort.Slice(duplicates, func(i, j int) bool {
+		return duplicates[i].Span < duplicates[j].Span
+	})
+
+	return duplicates
+}
+
+// WriteJSON writes the catalog as an indented JSON document.
+func (c *Catalog) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(c)
+}
+
+// WriteYAML writes the catalog as a YAML document. Strings are emitted
+// double-quoted, which YAML accepts with the same escaping rules as Go.
+func (c *Catalog) WriteYAML(w io.Writer) error {
+	buf := &bytes.Buffer{}
+
+	if len(c.Spans) == 0 {
+		fmt.Fprintln(buf, "spans: []")
+	} else {
+		fmt.Fprintln(buf, "spans:")
+	}
+
+	for _, e := range c.Spans {
+		fmt.Fprintf(buf, "  - span: %s\n", strconv.Quote(e.Span))
+		fmt.Fprintf(buf, "    function: %s\n", strconv.Quote(e.Function))
+		if e.Receiver != "" {
+			fmt.Fprintf(buf, "    receiver: %s\n", strconv.Quote(e.Receiver))
+		}
+		fmt.Fprintf(buf, "    package: %s\n", strconv.Quote(e.Package))
+		fmt.Fprintf(buf, "    file: %s\n", strconv.Quote(e.File))
+		fmt.Fprintf(buf, "    line: %d\n", e.Line)
+
+		if len(e.Directives) > 0 {
+			fmt.Fprintln(buf, "    directives:")
+			for _, d := range e.Directives {
+				fmt.Fprintf(buf, "      - %s\n", strconv.Quote(d))
+			}
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
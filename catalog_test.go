@@ -0,0 +1,151 @@
+package tracegen
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/dave/dst"
+)
+
+const catalogInput = `package main
+
+type T struct{}
+
+//trace:enable
+func (t *T) Method(x int) {}
+
+func Func(x int) {}
+
+//trace:skip
+func Skipped(x int) {}
+
+func NoParams() {}
+`
+
+const catalogYAML = `spans:
+  - span: "T.Method"
+    function: "Method"
+    receiver: "T"
+    package: "test"
+    file: "test/sample.go"
+    line: 6
+    directives:
+      - "trace:enable"
+  - span: "Func"
+    function: "Func"
+    package: "test"
+    file: "test/sample.go"
+    line: 8
+`
+
+func TestBuildCatalog(t *testing.T) {
+	path := writeModule(t, catalogInput)
+	err := os.Chdir(filepath.Dir(path))
+	check(t, err)
+
+	pkgs, err := LoadPackages([]string{"."})
+	check(t, err)
+
+	c, err := BuildCatalog(Settings{}, pkgs, func(fn *dst.FuncDecl) (state FuncState) {
+		state.Eligible = len(fn.Type.Params.List) > 0
+		state.SpanName = funcName(fn)
+
+		return state
+	})
+	check(t, err)
+
+	var spans []string
+	for _, e := range c.Spans {
+		spans = append(spans, e.Span)
+	}
+
+	if expected := []string{"T.Method", "Func"}; !reflect.DeepEqual(spans, expected) {
+		t.Fatalf("mismatched spans, got %v, expected %v", spans, expected)
+	}
+
+	buf := &bytes.Buffer{}
+	check(t, c.WriteYAML(buf))
+
+	if buf.String() != catalogYAML {
+		t.Fatalf("mismatched yaml:\ngot:\n%s\nexpected:\n%s", buf.String(), catalogYAML)
+	}
+}
+
+const duplicatesInput = `package main
+
+type T struct{}
+
+func (t *T) Foo(x int) {}
+
+func Foo(x int) {}
+
+func (t *T) Bar(x int) {}
+
+func Bar(x int) {}
+
+func Unique(x int) {}
+`
+
+const duplicatesSubpackage = `package sub
+
+func Foo(x int) {}
+`
+
+func TestDuplicates(t *testing.T) {
+	path := writeModule(t, duplicatesInput)
+	dir := filepath.Dir(path)
+
+	err := os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	check(t, err)
+
+	err = os.WriteFile(filepath.Join(dir, "sub", "sub.go"), []byte(duplicatesSubpackage), 0644)
+	check(t, err)
+
+	err = os.Chdir(dir)
+	check(t, err)
+
+	pkgs, err := LoadPackages([]string{"./..."})
+	check(t, err)
+
+	// Name spans after the bare function name, so methods collide with funcs
+	inspect := func(fn *dst.FuncDecl) (state FuncState) {
+		state.Eligible = len(fn.Type.Params.List) > 0
+		state.SpanName = fn.Name.Name
+
+		return state
+	}
+
+	c, err := BuildCatalog(Settings{}, pkgs, inspect)
+	check(t, err)
+
+	var got [][3]interface{}
+	for _, d := range c.Duplicates() {
+		got = append(got, [3]interface{}{d.Span, d.Scope, len(d.Entries)})
+	}
+
+	expected := [][3]interface{}{
+		{"Bar", "package", 2},
+		{"Foo", "module", 3},
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("mismatched duplicates, got %v, expected %v", got, expected)
+	}
+
+	findings, err := Check(Settings{}, pkgs, inspect)
+	check(t, err)
+
+	var warnings int
+	for _, f := range findings {
+		if f.Rule == RuleDuplicateName && f.Level == LevelWarning {
+			warnings++
+		}
+	}
+
+	if warnings != 5 {
+		t.Fatalf("expected 5 duplicate name warnings, got %v", findings)
+	}
+}
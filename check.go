@@ -0,0 +1,193 @@
+package tracegen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"sort"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// Rules reported by Check.
+const (
+	RuleMissing          = "missing-instrumentation"
+	RuleStale            = "stale-instrumentation"
+	RuleSkipped          = "skipped-instrumentation"
+	RuleUnknownDirective = "unknown-directive"
+	RuleDuplicateName    = "duplicate-name"
+	RuleUpdater          = "updater"
+	RuleEditedRegion     = "edited-region"
+	RuleUnverified       = "unverified"
+)
+
+// Severity levels of findings. Only errors should fail a check.
+const (
+	LevelError   = "error"
+	LevelWarning = "warning"
+)
+
+var ruleDescriptions = map[string]string{
+	RuleMissing:          "Function is missing instrumentation",
+	RuleStale:            "Function instrumentation is out of date",
+	RuleSkipped:          "Skipped function is still instrumented",
+	RuleUnknownDirective: "Unknown trace directive",
+	RuleDuplicateName:    "Span name is shared with other functions",
+	RuleUpdater:          "Warning reported by the updater",
+	RuleEditedRegion:     "Managed region was edited by hand",
+	RuleUnverified:       "Rewritten package could not be verified",
+}
+
+// A Finding is a single problem reported by Check, or a warning reported by
+// an updater.
+type Finding struct {
+	Rule    string `json:"rule"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+func (f Finding) String() string {
+	if f.Level == LevelWarning {
+		return fmt.Sprintf("%s:%d:%d: warning: %s", relPath(f.File), f.Line, f.Column, f.Message)
+	}
+
+	return fmt.Sprintf("%s:%d:%d: %s", relPath(f.File), f.Line, f.Column, f.Message)
+}
+
+// Check reports, without modifying anything, every function whose
+// instrumentation is missing, stale or present despite the function being
+// skipped, along with any unknown trace directives and managed regions (see
+// Markers) that were edited by hand. The same skip decisions
+// as ProcessPackages are applied.
+func Check(settings Settings, pkgs []*decorator.Package, inspect Inspector) (findings []Finding, err error) {
+	catalog, err := BuildCatalog(settings, pkgs, inspect)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range catalog.Duplicates() {
+		for i, e := range d.Entries {
+			var others []string
+			for j, other := range d.Entries {
+				if i != j {
+					others = append(others, fmt.Sprintf("%s:%d", other.File, other.Line))
+				}
+			}
+
+			message := fmt.Sprintf("span name %q is not unique within the %s; also used by %s", d.Span, d.Scope, strings.Join(others, ", "))
+
+			f := newFinding(RuleDuplicateName, message, token.Position{Filename: e.filename, Line: e.Line, Column: e.column})
+			f.Level = LevelWarning
+
+			findings = append(findings, f)
+		}
+	}
+
+	for _, pkg := range pkgs {
+		if _, ok := excluded(settings, pkg); ok {
+			continue
+		}
+
+		inspectFuncs(settings, pkg, func(file *dst.File, node *dst.FuncDecl, shouldSkip bool) {
+			if node.Body != nil {
+				if r, ok := findRegion(node.Body); ok && r.edited(node.Body) {
+					start, _ := position(pkg, node)
+					message := fmt.Sprintf("%s has a managed region that was edited by hand", funcName(node))
+					findings = append(findings, newFinding(RuleEditedRegion, message, start))
+				}
+			}
+
+			state := inspect(node)
+			if !state.Eligible {
+				return
+			}
+
+			rule, message, ok := checkFunc(node, state, shouldSkip)
+			if !ok {
+				return
+			}
+
+			start, _ := position(pkg, node)
+			findings = append(findings, newFinding(rule, message, start))
+		})
+
+		for _, file := range pkg.Syntax {
+			f, ok := pkg.Decorator.Ast.Nodes[file].(*ast.File)
+			if !ok {
+				continue
+			}
+
+			for _, group := range f.Comments {
+				for _, comment := range group.List {
+					m := directivePattern.FindStringSubmatch(comment.Text)
+					if m == nil {
+						continue
+					}
+
+					if _, ok := knownDirectives[m[1]]; ok {
+						continue
+					}
+
+					message := fmt.Sprintf("unknown directive %q", "trace:"+m[1])
+					findings = append(findings, newFinding(RuleUnknownDirective, message, pkg.Fset.Position(comment.Pos())))
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+
+	return findings, nil
+}
+
+// checkFunc returns the problem, if any, with the instrumentation of an
+// eligible function.
+func checkFunc(node *dst.FuncDecl, state FuncState, shouldSkip bool) (rule, message string, ok bool) {
+	switch {
+	case shouldSkip && state.Present:
+		return RuleSkipped, fmt.Sprintf("%s is skipped but still instrumented", funcName(node)), true
+	case shouldSkip:
+		return "", "", false
+	case !state.Present:
+		return RuleMissing, fmt.Sprintf("%s is missing instrumentation", funcName(node)), true
+	case state.Stale:
+		return RuleStale, fmt.Sprintf("%s has stale instrumentation", funcName(node)), true
+	}
+
+	return "", "", false
+}
+
+func newFinding(rule, message string, pos token.Position) Finding {
+	return Finding{
+		Rule:    rule,
+		Level:   LevelError,
+		Message: message,
+		File:    pos.Filename,
+		Line:    pos.Line,
+		Column:  pos.Column,
+	}
+}
+
+// funcName returns the name of fn, qualified by its receiver type if any.
+func funcName(fn *dst.FuncDecl) string {
+	if recv := receiverName(fn); recv != "" {
+		return recv + "." + fn.Name.Name
+	}
+
+	return fn.Name.Name
+}
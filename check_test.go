@@ -0,0 +1,123 @@
+package tracegen
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/dave/dst"
+)
+
+const checkInput = `package main
+
+func Traced(x int) {
+	println("Traced")
+}
+
+func Missing(x int) {}
+
+func Renamed(x int) {
+	println("Old")
+}
+
+//trace:skip
+func Skipped(x int) {
+	println("Skipped")
+}
+
+//trace:unknown
+func Unknown() {}
+
+//trace:skipped
+func Misspelled(x int) {
+	println("Misspelled")
+}
+`
+
+// inspectPrintln treats functions with parameters as eligible, and a leading
+// println of the function's name as its instrumentation.
+func inspectPrintln(fn *dst.FuncDecl) (state FuncState) {
+	state.Eligible = len(fn.Type.Params.List) > 0
+	if fn.Body == nil || len(fn.Body.List) == 0 {
+		return state
+	}
+
+	state.Present = true
+
+	call := fn.Body.List[0].(*dst.ExprStmt).X.(*dst.CallExpr)
+	state.Stale = call.Args[0].(*dst.BasicLit).Value != strconv.Quote(fn.Name.Name)
+
+	return state
+}
+
+func TestCheck(t *testing.T) {
+	path := writeModule(t, checkInput)
+	err := os.Chdir(filepath.Dir(path))
+	check(t, err)
+
+	pkgs, err := LoadPackages([]string{"."})
+	check(t, err)
+
+	findings, err := Check(Settings{}, pkgs, inspectPrintln)
+	check(t, err)
+
+	var got [][2]interface{}
+	for _, f := range findings {
+		got = append(got, [2]interface{}{f.Rule, f.Line})
+	}
+
+	expected := [][2]interface{}{
+		{RuleMissing, 7},
+		{RuleStale, 9},
+		{RuleSkipped, 14},
+		{RuleUnknownDirective, 18},
+		{RuleUnknownDirective, 21},
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("mismatched findings, got %v, expected %v", got, expected)
+	}
+
+	buf := &bytes.Buffer{}
+	check(t, WriteSARIF(buf, findings))
+
+	var log struct {
+		Version string
+		Runs    []struct {
+			Results []struct {
+				RuleID    string
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct{ URI string }
+						Region           struct{ StartLine, StartColumn int }
+					}
+				}
+			}
+		}
+	}
+
+	check(t, json.Unmarshal(buf.Bytes(), &log))
+
+	if log.Version != "2.1.0" || len(log.Runs) != 1 || len(log.Runs[0].Results) != len(expected) {
+		t.Fatalf("unexpected SARIF log:\n%s", buf.String())
+	}
+
+	loc := log.Runs[0].Results[0].Locations[0].PhysicalLocation
+	if loc.ArtifactLocation.URI != "sample.go" || loc.Region.StartLine != 7 || loc.Region.StartColumn != 1 {
+		t.Fatalf("unexpected location %+v", loc)
+	}
+}
+
+func TestRuleDescriptions(t *testing.T) {
+	rules := []string{RuleMissing, RuleStale, RuleSkipped, RuleUnknownDirective, RuleDuplicateName, RuleUpdater, RuleEditedRegion, RuleUnverified}
+
+	for _, rule := range rules {
+		if ruleDescriptions[rule] == "" {
+			t.Fatalf("rule %s has no description, so SARIF logs would omit it", rule)
+		}
+	}
+}
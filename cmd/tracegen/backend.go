@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+
+	"github.com/Deiz/tracegen"
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+// backend is the updater, inspector and resolver used to manage the code
+// injected into functions.
+type backend struct {
+	update      tracegen.Updater
+	inspect     tracegen.Inspector
+	getResolver func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver
+
+	// key identifies the backend in cache keys.
+	key string
+}
+
+// defaultBackend manages opentracing spans.
+var defaultBackend = backend{
+	update:      tracegen.UpdateFunc(update),
+	inspect:     inspect,
+	getResolver: getResolver,
+	key:         "opentracing",
+}
+
+// templateFlag adds the --template flag to flags.
+func templateFlag(flags *pflag.FlagSet) *string {
+	return flags.String("template", "", "if specified, inject the statements defined by this template file rather than opentracing spans")
+}
+
+// loadBackend returns the backend for the given template file, or the default
+// backend if filename is empty.
+func loadBackend(filename string) (backend, error) {
+	if filename == "" {
+		return defaultBackend, nil
+	}
+
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return backend{}, errors.Wrap(err, "failed to read template")
+	}
+
+	t, err := tracegen.NewTemplateUpdater(src)
+	if err != nil {
+		return backend{}, err
+	}
+
+	sum := sha256.Sum256(src)
+
+	return backend{
+		update:  t,
+		inspect: t.Inspect,
+		getResolver: func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+			return tracegen.NewSimpleResolver(pkg, file, t.Hints())
+		},
+		key: "template " + hex.EncodeToString(sum[:]),
+	}, nil
+}
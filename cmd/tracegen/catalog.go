@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/Deiz/tracegen"
+)
+
+// catalogCommand writes a catalog of every span managed by tracegen within the
+// matching packages.
+func catalogCommand(args []string) {
+	settings := defaultSettings()
+	flags := tracegen.DefaultFlags(&settings)
+
+	format := flags.String("format", "json", "catalog format (json or yaml)")
+	output := flags.String("output", "", "if specified, write the catalog to this file rather than stdout")
+
+	template := templateFlag(flags)
+
+	parseFlags(flags, &settings, args)
+
+	b, err := loadBackend(*template)
+	if err != nil {
+		log.Fatalf("failed to load template: %v", err)
+	}
+
+	if err := writeCatalog(settings, b.inspect, flags.Args(), *format, *output); err != nil {
+		log.Fatalf("failed to write catalog: %v", err)
+	}
+}
+
+func writeCatalog(settings tracegen.Settings, inspect tracegen.Inspector, patterns []string, format string, output string) (err error) {
+	if format != "json" && format != "yaml" {
+		return fmt.Errorf("unknown catalog format %q", format)
+	}
+
+	pkgs, err := tracegen.LoadPackages(patterns)
+	if err != nil {
+		return err
+	}
+
+	catalog, err := tracegen.BuildCatalog(settings, pkgs, inspect)
+	if err != nil {
+		return err
+	}
+
+	w, err := createOutput(output)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if format == "yaml" {
+		return catalog.WriteYAML(w)
+	}
+
+	return catalog.WriteJSON(w)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Deiz/tracegen"
+	"github.com/pkg/errors"
+)
+
+// runCheck reports problems with the instrumentation of the packages matching
+// patterns, and fails if there are any errors. Duplicate span names are only
+// treated as errors if failOnDuplicates is set.
+func runCheck(settings tracegen.Settings, inspect tracegen.Inspector, patterns []string, format string, output string, failOnDuplicates bool) (err error) {
+	if format != "text" && format != "sarif" {
+		return fmt.Errorf("unknown check format %q", format)
+	}
+
+	pkgs, err := tracegen.LoadPackages(patterns)
+	if err != nil {
+		return err
+	}
+
+	findings, err := tracegen.Check(settings, pkgs, inspect)
+	if err != nil {
+		return err
+	}
+
+	var errs int
+	for i, f := range findings {
+		if f.Rule == tracegen.RuleDuplicateName && failOnDuplicates {
+			findings[i].Level = tracegen.LevelError
+		}
+
+		if findings[i].Level == tracegen.LevelError {
+			errs++
+		}
+	}
+
+	w, err := createOutput(output)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	switch format {
+	case "text":
+		for _, f := range findings {
+			fmt.Fprintln(w, f)
+		}
+	case "sarif":
+		err = tracegen.WriteSARIF(w, findings)
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "failed to write findings")
+	}
+
+	if errs > 0 {
+		return fmt.Errorf("found %d problem(s)", errs)
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Deiz/tracegen"
+	"github.com/pkg/errors"
+)
+
+// reportCoverage writes an instrumentation coverage report for the packages
+// matching patterns, and fails if coverage is below min.
+func reportCoverage(settings tracegen.Settings, inspect tracegen.Inspector, patterns []string, format string, output string, min float64) (err error) {
+	if format != "" && format != "text" && format != "json" && format != "html" {
+		return fmt.Errorf("unknown coverage format %q", format)
+	}
+
+	pkgs, err := tracegen.LoadPackages(patterns)
+	if err != nil {
+		return err
+	}
+
+	coverage, err := tracegen.MeasureCoverage(settings, pkgs, inspect)
+	if err != nil {
+		return err
+	}
+
+	w, err := createOutput(output)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	switch format {
+	case "", "text":
+		err = coverage.WriteText(w)
+	case "json":
+		err = coverage.WriteJSON(w)
+	case "html":
+		err = coverage.WriteHTML(w)
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "failed to write coverage")
+	}
+
+	if coverage.Percent < min {
+		return fmt.Errorf("coverage of %.1f%% is below the minimum of %.1f%%", coverage.Percent, min)
+	}
+
+	return nil
+}
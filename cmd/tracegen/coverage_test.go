@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReportCoverageUnknownFormat(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "coverage.txt")
+
+	err := os.WriteFile(output, []byte("previous"), 0644)
+	check(t, err)
+
+	if err := reportCoverage(defaultSettings(), nil, []string{"./..."}, "xml", output, 0); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+
+	data, err := os.ReadFile(output)
+	check(t, err)
+
+	if string(data) != "previous" {
+		t.Fatalf("expected the output file to be left untouched, got %q", data)
+	}
+}
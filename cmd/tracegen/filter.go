@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/Deiz/tracegen"
+	"github.com/pkg/errors"
+)
+
+// stdinFilename is the name given to source read from stdin when --srcdir
+// names a directory rather than a file.
+const stdinFilename = "tracegen_stdin.go"
+
+// filter reads a single Go file from r and writes it to w with the updater
+// applied, like gofmt. The file is processed as part of the package in srcdir,
+// which may instead name the file itself, in which case the file on disk is
+// ignored in favour of r. Nothing is written to disk.
+func filter(settings tracegen.Settings, b backend, srcdir string, r io.Reader, w io.Writer) error {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "failed to read stdin")
+	}
+
+	srcdir, err = filepath.Abs(srcdir)
+	if err != nil {
+		return errors.Wrap(err, "failed to resolve srcdir")
+	}
+
+	dir, filename := srcdir, filepath.Join(srcdir, stdinFilename)
+	if info, err := os.Stat(srcdir); (err == nil && !info.IsDir()) || (err != nil && filepath.Ext(srcdir) == ".go") {
+		dir, filename = filepath.Dir(srcdir), srcdir
+	}
+
+	fs := tracegen.NewOverlayFS(map[string][]byte{filename: src})
+
+	p := &tracegen.Processor{
+		Settings:    settings,
+		Updater:     b.update,
+		GetResolver: b.getResolver,
+		FS:          fs,
+		Dir:         dir,
+	}
+
+	result, err := p.Process([]string{"."})
+	if result != nil {
+		for _, warning := range result.Warnings {
+			log.Print(warning)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if data, ok := fs.Changes()[filename]; ok && data != nil {
+		src = data
+	}
+
+	_, err = w.Write(src)
+	return err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const filterTemplate = `package template
+
+func _(__ctx__ int) {
+	println(__ctx__, "__span__")
+}
+`
+
+const filterInput = `package main
+
+func Foo(ctx int) {}
+`
+
+const filterOutput = `package main
+
+func Foo(ctx int) {
+	println(ctx, "Foo")
+}
+`
+
+func TestFilter(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	check(t, err)
+
+	err = os.WriteFile(filepath.Join(dir, "go.mod"), []byte(gomod), 0644)
+	check(t, err)
+
+	template := filepath.Join(dir, "template.go.tmpl")
+	err = os.WriteFile(template, []byte(filterTemplate), 0644)
+	check(t, err)
+
+	b, err := loadBackend(template)
+	check(t, err)
+
+	// The file on disk differs from stdin, which takes precedence
+	path := filepath.Join(dir, "main.go")
+	err = os.WriteFile(path, []byte(strings.Replace(filterInput, "Foo", "Bar", 1)), 0644)
+	check(t, err)
+
+	for _, srcdir := range []string{dir, path} {
+		out := &bytes.Buffer{}
+
+		err = filter(defaultSettings(), b, srcdir, strings.NewReader(filterInput), out)
+		check(t, err)
+
+		if out.String() != filterOutput {
+			t.Fatalf("mismatched output for srcdir %s:\ngot:\n%s\nexpected:\n%s", srcdir, out.String(), filterOutput)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	check(t, err)
+
+	if len(entries) != 3 {
+		t.Fatalf("expected nothing to be written to disk, found %d files", len(entries))
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/Deiz/tracegen"
+	"github.com/pkg/errors"
+)
+
+// goGenerate returns the package patterns, and the diff if any, that limit a
+// run to the file or package (per scope) that go generate was invoked for,
+// given its environment. go generate runs commands in the file's directory.
+func goGenerate(scope string, getenv func(key string) string) (patterns []string, diff tracegen.Diff, err error) {
+	file, pkg := getenv("GOFILE"), getenv("GOPACKAGE")
+	if file == "" || pkg == "" {
+		return nil, nil, errors.New("GOFILE and GOPACKAGE are not set; --gogenerate must be invoked by go generate")
+	}
+
+	// Only the package's non-test files are loaded
+	if strings.HasSuffix(file, "_test.go") || strings.HasSuffix(pkg, "_test") {
+		return nil, nil, fmt.Errorf("cannot instrument test file %s", file)
+	}
+
+	switch scope {
+	case "package":
+		return []string{"."}, nil, nil
+	case "file":
+		path, err := filepath.Abs(file)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to resolve %s", file)
+		}
+
+		return []string{"."}, tracegen.Diff{path: nil}, nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown --gogenerate-scope %q, expected file or package", scope)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Deiz/tracegen"
+)
+
+func TestGoGenerate(t *testing.T) {
+	path := writeModule(t, filterInput)
+	dir := filepath.Dir(path)
+
+	err := os.Chdir(dir)
+	check(t, err)
+
+	template := filepath.Join(dir, "template.go.tmpl")
+	err = os.WriteFile(template, []byte(filterTemplate), 0644)
+	check(t, err)
+
+	b, err := loadBackend(template)
+	check(t, err)
+
+	other := filepath.Join(dir, "other.go")
+	otherInput := strings.Replace(filterInput, "Foo", "Bar", 1)
+
+	env := map[string]string{"GOFILE": "sample.go", "GOPACKAGE": "main"}
+
+	tests := []struct {
+		scope   string
+		changed []string
+	}{
+		{"file", []string{path}},
+		{"package", []string{other, path}},
+	}
+
+	for _, test := range tests {
+		err = os.WriteFile(path, []byte(filterInput), 0644)
+		check(t, err)
+
+		err = os.WriteFile(other, []byte(otherInput), 0644)
+		check(t, err)
+
+		patterns, diff, err := goGenerate(test.scope, func(key string) string { return env[key] })
+		check(t, err)
+
+		p := &tracegen.Processor{
+			Settings:    defaultSettings(),
+			Updater:     b.update,
+			GetResolver: b.getResolver,
+			Diff:        diff,
+		}
+
+		result, err := p.Process(patterns)
+		check(t, err)
+
+		if changed := result.Changed(); strings.Join(changed, " ") != strings.Join(test.changed, " ") {
+			t.Fatalf("expected %v to change for scope %s, got %v", test.changed, test.scope, changed)
+		}
+	}
+
+	if _, _, err := goGenerate("package", func(string) string { return "" }); err == nil {
+		t.Fatal("expected an error outside of go generate")
+	}
+
+	env["GOFILE"] = "sample_test.go"
+	if _, _, err := goGenerate("file", func(key string) string { return env[key] }); err == nil {
+		t.Fatal("expected an error for a test file")
+	}
+}
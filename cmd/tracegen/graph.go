@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/Deiz/tracegen"
+)
+
+// graphCommand writes the expected span hierarchy of the matching packages.
+func graphCommand(args []string) {
+	settings := defaultSettings()
+	flags := tracegen.DefaultFlags(&settings)
+
+	format := flags.String("format", "dot", "graph format (dot or mermaid)")
+	output := flags.String("output", "", "if specified, write the graph to this file rather than stdout")
+
+	template := templateFlag(flags)
+
+	parseFlags(flags, &settings, args)
+
+	b, err := loadBackend(*template)
+	if err != nil {
+		log.Fatalf("failed to load template: %v", err)
+	}
+
+	if err := writeGraph(settings, b.inspect, flags.Args(), *format, *output); err != nil {
+		log.Fatalf("failed to write graph: %v", err)
+	}
+}
+
+func writeGraph(settings tracegen.Settings, inspect tracegen.Inspector, patterns []string, format string, output string) (err error) {
+	if format != "dot" && format != "mermaid" {
+		return fmt.Errorf("unknown graph format %q", format)
+	}
+
+	// The call graph needs type information
+	pkgs, err := (&tracegen.Processor{Types: true}).Load(patterns)
+	if err != nil {
+		return err
+	}
+
+	graph, err := tracegen.BuildGraph(settings, pkgs, inspect)
+	if err != nil {
+		return err
+	}
+
+	w, err := createOutput(output)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if format == "mermaid" {
+		return graph.WriteMermaid(w)
+	}
+
+	return graph.WriteDOT(w)
+}
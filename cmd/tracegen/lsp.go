@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/Deiz/tracegen"
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/pkg/errors"
+)
+
+// lspCommand runs a minimal language server over stdio, offering code actions
+// that add or remove instrumentation.
+func lspCommand(args []string) {
+	settings := defaultSettings()
+	flags := tracegen.DefaultFlags(&settings)
+	template := templateFlag(flags)
+
+	if err := flags.Parse(args); err != nil {
+		log.Fatalf("failed to parse flags: %v", err)
+	}
+
+	if err := settings.Parse(); err != nil {
+		log.Fatalf("failed to parse settings: %v", err)
+	}
+
+	b, err := loadBackend(*template)
+	if err != nil {
+		log.Fatalf("failed to load template: %v", err)
+	}
+
+	if err := newLSPServer(settings, b).serve(os.Stdin, os.Stdout); err != nil {
+		log.Fatalf("language server failed: %v", err)
+	}
+}
+
+// JSON-RPC error codes
+const (
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+)
+
+type rpcRequest struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+	Error   *rpcError        `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// The subset of the protocol used by the server
+type (
+	lspPosition struct {
+		Line      int `json:"line"`
+		Character int `json:"character"`
+	}
+
+	lspRange struct {
+		Start lspPosition `json:"start"`
+		End   lspPosition `json:"end"`
+	}
+
+	lspTextEdit struct {
+		Range   lspRange `json:"range"`
+		NewText string   `json:"newText"`
+	}
+
+	lspWorkspaceEdit struct {
+		Changes map[string][]lspTextEdit `json:"changes"`
+	}
+
+	lspCodeAction struct {
+		Title string            `json:"title"`
+		Kind  string            `json:"kind"`
+		Edit  *lspWorkspaceEdit `json:"edit"`
+	}
+
+	lspTextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	}
+
+	lspDidOpenParams struct {
+		TextDocument lspTextDocument `json:"textDocument"`
+	}
+
+	lspDidChangeParams struct {
+		TextDocument   lspTextDocument `json:"textDocument"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+	}
+
+	lspCodeActionParams struct {
+		TextDocument lspTextDocument `json:"textDocument"`
+		Range        lspRange        `json:"range"`
+	}
+)
+
+// lspServer tracks the open documents, and computes code actions by loading
+// their packages with the documents as an overlay. Requests are handled one
+// at a time.
+type lspServer struct {
+	settings tracegen.Settings
+	backend  backend
+
+	// Open documents, keyed by filename
+	docs map[string][]byte
+}
+
+func newLSPServer(settings tracegen.Settings, b backend) *lspServer {
+	return &lspServer{settings: settings, backend: b, docs: make(map[string][]byte)}
+}
+
+// serve handles messages from r until the client sends exit, or r is closed.
+func (s *lspServer) serve(r io.Reader, w io.Writer) error {
+	in := textproto.NewReader(bufio.NewReader(r))
+
+	for {
+		header, err := in.ReadMIMEHeader()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read header")
+		}
+
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil {
+			return errors.Wrap(err, "invalid Content-Length")
+		}
+
+		body := make([]byte, length)
+		if _, err := io.ReadFull(in.R, body); err != nil {
+			return errors.Wrap(err, "failed to read message")
+		}
+
+		var req rpcRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return errors.Wrap(err, "failed to decode message")
+		}
+
+		if req.Method == "exit" {
+			return nil
+		}
+
+		result, rpcErr := s.handle(req)
+
+		// Notifications have no ID, and don't get a response
+		if req.ID == nil {
+			if rpcErr != nil {
+				log.Printf("%s: %s", req.Method, rpcErr.Message)
+			}
+
+			continue
+		}
+
+		if err := writeMessage(w, rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rpcErr}); err != nil {
+			return err
+		}
+	}
+}
+
+func writeMessage(w io.Writer, msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode message")
+	}
+
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(data), data); err != nil {
+		return errors.Wrap(err, "failed to write message")
+	}
+
+	return nil
+}
+
+func (s *lspServer) handle(req rpcRequest) (result interface{}, rpcErr *rpcError) {
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				// Full document sync
+				"textDocumentSync":   1,
+				"codeActionProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "tracegen"},
+		}, nil
+	case "shutdown":
+		return nil, nil
+	case "textDocument/didOpen":
+		var params lspDidOpenParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+		}
+
+		s.docs[uriToPath(params.TextDocument.URI)] = []byte(params.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var params lspDidChangeParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+		}
+
+		if n := len(params.ContentChanges); n > 0 {
+			s.docs[uriToPath(params.TextDocument.URI)] = []byte(params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var params lspDidOpenParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+		}
+
+		delete(s.docs, uriToPath(params.TextDocument.URI))
+		return nil, nil
+	case "textDocument/codeAction":
+		var params lspCodeActionParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+		}
+
+		actions, err := s.codeActions(params.TextDocument.URI, params.Range)
+		if err != nil {
+			return nil, &rpcError{Code: codeInternalError, Message: err.Error()}
+		}
+
+		return actions, nil
+	case "initialized", "$/cancelRequest", "$/setTrace":
+		return nil, nil
+	}
+
+	return nil, &rpcError{Code: codeMethodNotFound, Message: fmt.Sprintf("method %q not found", req.Method)}
+}
+
+// codeActions returns the actions available at rng in the document at uri.
+func (s *lspServer) codeActions(uri string, rng lspRange) (actions []lspCodeAction, err error) {
+	filename := uriToPath(uri)
+
+	src, ok := s.docs[filename]
+	if !ok {
+		if src, err = os.ReadFile(filename); err != nil {
+			return nil, errors.Wrap(err, "failed to read document")
+		}
+	}
+
+	fs := tracegen.NewOverlayFS(s.docs)
+
+	p := &tracegen.Processor{
+		Settings:    s.settings,
+		Updater:     s.backend.update,
+		GetResolver: s.backend.getResolver,
+		FS:          fs,
+		Dir:         filepath.Dir(filename),
+	}
+
+	pkgs, err := p.Load([]string{"."})
+	if err != nil {
+		return nil, err
+	}
+
+	pkg, file := findFile(pkgs, filename)
+	if file == nil {
+		return nil, nil
+	}
+
+	tokFile := pkg.Fset.File(pkg.Decorator.Ast.Nodes[file].Pos())
+
+	// toLSP converts tracegen's edits to edits of the document
+	toLSP := func(edits []tracegen.Edit) *lspWorkspaceEdit {
+		var changes []lspTextEdit
+		for _, e := range edits {
+			changes = append(changes, lspTextEdit{
+				Range: lspRange{
+					Start: positionOf(src, tokFile.Offset(e.Pos)),
+					End:   positionOf(src, tokFile.Offset(e.End)),
+				},
+				NewText: string(e.NewText),
+			})
+		}
+
+		return &lspWorkspaceEdit{Changes: map[string][]lspTextEdit{uri: changes}}
+	}
+
+	offset := offsetOf(src, rng.Start)
+	if offset <= tokFile.Size() {
+		if _, fn := tracegen.FuncAt(pkg, tokFile.Pos(offset)); fn != nil {
+			actions, err = s.funcActions(pkg, fn, toLSP)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Processing the package modifies it, so this must come last
+	if _, err := p.ProcessPackages([]*decorator.Package{pkg}); err != nil {
+		return nil, err
+	}
+
+	if data, ok := fs.Changes()[filename]; ok && data != nil {
+		actions = append(actions, lspCodeAction{
+			Title: "Add tracing to all functions in file",
+			Kind:  "source",
+			Edit: &lspWorkspaceEdit{Changes: map[string][]lspTextEdit{uri: {{
+				Range:   lspRange{End: positionOf(src, len(src))},
+				NewText: string(data),
+			}}}},
+		})
+	}
+
+	return actions, nil
+}
+
+// funcActions returns the actions available for fn, which apply the updater
+// regardless of the function's skip decision.
+func (s *lspServer) funcActions(pkg *decorator.Package, fn *dst.FuncDecl, toLSP func([]tracegen.Edit) *lspWorkspaceEdit) (actions []lspCodeAction, err error) {
+	state := s.backend.inspect(fn)
+	if !state.Eligible {
+		return nil, nil
+	}
+
+	name := fn.Name.Name
+
+	add := func(title string, prepare func(fc *tracegen.FuncContext)) error {
+		edits, err := tracegen.EditFunc(s.settings, pkg, fn, s.backend.update, s.backend.getResolver, prepare)
+		if err != nil {
+			return err
+		}
+
+		actions = append(actions, lspCodeAction{Title: title, Kind: "refactor.rewrite", Edit: toLSP(edits)})
+		return nil
+	}
+
+	if !state.Present || state.Stale {
+		err := add(fmt.Sprintf("Add tracing to %s", name), func(fc *tracegen.FuncContext) {
+			fc.ShouldSkip, fc.SkipReason = false, ""
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if state.Present {
+		err := add(fmt.Sprintf("Remove tracing from %s", name), func(fc *tracegen.FuncContext) {
+			fc.ShouldSkip = true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !hasDirective(fn, "trace:skip") {
+		err := add(fmt.Sprintf("Skip tracing for %s with //trace:skip", name), func(fc *tracegen.FuncContext) {
+			fc.Func.Decs.Start.Append("//trace:skip")
+			fc.ShouldSkip, fc.SkipReason = true, "function is tagged trace:skip"
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return actions, nil
+}
+
+// findFile returns the package and file with the given filename.
+func findFile(pkgs []*decorator.Package, filename string) (*decorator.Package, *dst.File) {
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Syntax {
+			if pkg.Decorator.Filenames[file] == filename {
+				return pkg, file
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func hasDirective(fn *dst.FuncDecl, directive string) bool {
+	for _, dec := range fn.Decs.Start {
+		if strings.TrimSpace(strings.TrimPrefix(dec, "//")) == directive {
+			return true
+		}
+	}
+
+	return false
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+
+	return filepath.FromSlash(u.Path)
+}
+
+// offsetOf returns the byte offset in src of p, whose character offset is in
+// UTF-16 code units.
+func offsetOf(src []byte, p lspPosition) int {
+	offset := 0
+	for line := 0; line < p.Line; line++ {
+		i := strings.IndexByte(string(src[offset:]), '\n')
+		if i < 0 {
+			return len(src)
+		}
+
+		offset += i + 1
+	}
+
+	for units := 0; units < p.Character && offset < len(src) && src[offset] != '\n'; {
+		r, size := utf8.DecodeRune(src[offset:])
+		units += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+
+	return offset
+}
+
+// positionOf returns the position of the byte offset in src, with the
+// character offset in UTF-16 code units.
+func positionOf(src []byte, offset int) (p lspPosition) {
+	lineStart := 0
+	for i := 0; i < offset && i < len(src); i++ {
+		if src[i] == '\n' {
+			p.Line++
+			lineStart = i + 1
+		}
+	}
+
+	for _, r := range string(src[lineStart:offset]) {
+		p.Character += len(utf16.Encode([]rune{r}))
+	}
+
+	return p
+}
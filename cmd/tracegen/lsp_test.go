@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+const lspInput = `package main
+
+func Foo(ctx int) {
+	println("body")
+}
+`
+
+// lspClient is a minimal JSON-RPC client for testing the server.
+type lspClient struct {
+	t  *testing.T
+	w  io.Writer
+	r  *textproto.Reader
+	id int
+}
+
+func (c *lspClient) send(method string, params interface{}, notify bool) {
+	c.t.Helper()
+
+	msg := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+	if !notify {
+		c.id++
+		msg["id"] = c.id
+	}
+
+	check(c.t, writeMessage(c.w, msg))
+}
+
+func (c *lspClient) call(method string, params interface{}, result interface{}) {
+	c.t.Helper()
+
+	c.send(method, params, false)
+
+	header, err := c.r.ReadMIMEHeader()
+	check(c.t, err)
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	check(c.t, err)
+
+	body := make([]byte, length)
+	_, err = io.ReadFull(c.r.R, body)
+	check(c.t, err)
+
+	var resp struct {
+		ID     int             `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+
+	check(c.t, json.Unmarshal(body, &resp))
+
+	if resp.ID != c.id {
+		c.t.Fatalf("mismatched response id, got %d, expected %d", resp.ID, c.id)
+	}
+
+	if resp.Error != nil {
+		c.t.Fatalf("%s failed: %s", method, resp.Error.Message)
+	}
+
+	if result != nil {
+		check(c.t, json.Unmarshal(resp.Result, result))
+	}
+}
+
+func TestLSP(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	check(t, err)
+
+	err = os.WriteFile(filepath.Join(dir, "go.mod"), []byte(gomod), 0644)
+	check(t, err)
+
+	template := filepath.Join(dir, "template.go.tmpl")
+	err = os.WriteFile(template, []byte(filterTemplate), 0644)
+	check(t, err)
+
+	b, err := loadBackend(template)
+	check(t, err)
+
+	// The document is only open in the editor, not saved to disk
+	path := filepath.Join(dir, "main.go")
+	uri := "file://" + filepath.ToSlash(path)
+
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+
+	done := make(chan error)
+	go func() {
+		done <- newLSPServer(defaultSettings(), b).serve(serverIn, serverOut)
+	}()
+
+	c := &lspClient{t: t, w: clientOut, r: textproto.NewReader(bufio.NewReader(clientIn))}
+
+	c.call("initialize", map[string]interface{}{}, nil)
+	c.send("initialized", map[string]interface{}{}, true)
+	c.send("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "go", "version": 1, "text": lspInput},
+	}, true)
+
+	var actions []lspCodeAction
+	c.call("textDocument/codeAction", map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"range":        lspRange{Start: lspPosition{Line: 3, Character: 1}, End: lspPosition{Line: 3, Character: 1}},
+		"context":      map[string]interface{}{"diagnostics": []interface{}{}},
+	}, &actions)
+
+	expected := map[string]string{
+		"Add tracing to Foo": "package main\n\nfunc Foo(ctx int) {\n\tprintln(ctx, \"Foo\")\n\n\tprintln(\"body\")\n}\n",
+
+		"Skip tracing for Foo with //trace:skip": "package main\n\n//trace:skip\nfunc Foo(ctx int) {\n\tprintln(\"body\")\n}\n",
+
+		"Add tracing to all functions in file": "package main\n\nfunc Foo(ctx int) {\n\tprintln(ctx, \"Foo\")\n\n\tprintln(\"body\")\n}\n",
+	}
+
+	if len(actions) != len(expected) {
+		t.Fatalf("expected %d actions, got %+v", len(expected), actions)
+	}
+
+	for _, action := range actions {
+		output := applyTextEdits(lspInput, action.Edit.Changes[uri])
+		if output != expected[action.Title] {
+			t.Errorf("mismatched output for %q:\ngot:\n%s\nexpected:\n%s", action.Title, output, expected[action.Title])
+		}
+	}
+
+	c.call("shutdown", nil, nil)
+	c.send("exit", nil, true)
+
+	check(t, <-done)
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing to be written to disk, got %v", err)
+	}
+}
+
+func applyTextEdits(src string, edits []lspTextEdit) string {
+	sort.Slice(edits, func(i, j int) bool {
+		return offsetOf([]byte(src), edits[i].Range.Start) > offsetOf([]byte(src), edits[j].Range.Start)
+	})
+
+	for _, e := range edits {
+		start, end := offsetOf([]byte(src), e.Range.Start), offsetOf([]byte(src), e.Range.End)
+		src = src[:start] + e.NewText + src[end:]
+	}
+
+	return src
+}
+
+func TestLSPPositions(t *testing.T) {
+	src := []byte("a\n\u00e9\U0001F600x\n")
+
+	for offset, p := range map[int]lspPosition{0: {0, 0}, 2: {1, 0}, 4: {1, 1}, 8: {1, 3}, 10: {2, 0}} {
+		if got := positionOf(src, offset); got != p {
+			t.Errorf("mismatched position for offset %d, got %+v, expected %+v", offset, got, p)
+		}
+
+		if got := offsetOf(src, p); got != offset {
+			t.Errorf("mismatched offset for %+v, got %d, expected %d", p, got, offset)
+		}
+	}
+}
@@ -1,31 +1,233 @@
 package main
 
 import (
+	"io"
 	"log"
 	"os"
+	"runtime"
 
 	"github.com/Deiz/tracegen"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
 )
 
+// commands are invoked when named by the first argument, in place of the
+// default behaviour of updating the matching packages.
+var commands = map[string]func(args []string){
+	"catalog": catalogCommand,
+	"graph":   graphCommand,
+	"lsp":     lspCommand,
+	"vet":     vetCommand,
+	"watch":   watchCommand,
+}
+
 func main() {
-	settings := tracegen.DefaultSettings()
-	settings.Exclude = append(settings.Exclude, `/generated(/|$)`)
+	if invokedByVet(os.Args[1:]) {
+		vetCommand(os.Args[1:])
+		return
+	}
 
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+
+	settings := defaultSettings()
 	flags := tracegen.DefaultFlags(&settings)
 
-	if err := flags.Parse(os.Args[1:]); err != nil {
-		log.Fatalf("failed to parse flags: %v", err)
+	coverage := flags.String("coverage", "", "if specified, report instrumentation coverage (text, json or html) instead of updating files")
+	flags.Lookup("coverage").NoOptDefVal = "text"
+	coverageOutput := flags.String("coverage-output", "", "if specified, write the coverage report to this file rather than stdout")
+	minCoverage := flags.Float64("min-coverage", 0, "if specified, report coverage and fail if it is below this percentage")
+
+	checkFormat := flags.String("check", "", "if specified, report missing or stale instrumentation (text or sarif) instead of updating files")
+	flags.Lookup("check").NoOptDefVal = "text"
+	checkOutput := flags.String("check-output", "", "if specified, write check findings to this file rather than stdout")
+	failOnDuplicates := flags.Bool("fail-on-duplicate-names", false, "if specified, check mode fails if several functions share a span name; implies --check")
+
+	report := flags.String("report", "", "if specified, write a report of the run in the given format (json)")
+	reportOutput := flags.String("report-output", "", "if specified, write the report to this file rather than stdout")
+
+	template := templateFlag(flags)
+	jobs := flags.Int("jobs", runtime.NumCPU(), "the maximum number of packages to process concurrently")
+	srcdir := flags.String("srcdir", ".", "when reading from stdin, process the source as if it were in this directory, or this file")
+	cache := flags.Bool("cache", false, "if specified, skip packages that were left unchanged by an earlier run with the same files and flags")
+	cacheDir := flags.String("cache-dir", "", "the directory to store the cache in, defaulting to tracegen's directory within the user's cache directory")
+	since := flags.String("since", "", "if specified, only update functions changed since the merge base of this git ref and HEAD, including uncommitted changes")
+	staged := flags.Bool("staged", false, "if specified, only update functions with staged changes")
+	generate := flags.Bool("gogenerate", false, "if specified, instrument the package go generate was invoked for, rather than matching patterns")
+	goGenerateScope := flags.String("gogenerate-scope", "package", "with --gogenerate, whether to instrument the whole package (package) or only the file containing the directive (file)")
+	verify := flags.Bool("verify", false, "if specified, type-check each rewritten package before writing it, and write nothing if any fails")
+	markers := flags.Bool("markers", false, "if specified, wrap generated code in tracegen:begin and tracegen:end comments, and leave hand-edited regions untouched")
+
+	parseArgs(flags, &settings, os.Args[1:])
+
+	if *report != "" {
+		if err := checkReportFormat(*report); err != nil {
+			log.Fatal(err)
+		}
 	}
 
+	patterns := flags.Args()
+
+	var generated tracegen.Diff
+
+	if *generate {
+		if flags.NArg() > 0 {
+			log.Fatal("cannot specify patterns with --gogenerate")
+		}
+
+		if *since != "" || *staged {
+			log.Fatal("cannot combine --gogenerate with --since or --staged")
+		}
+
+		var err error
+		if patterns, generated, err = goGenerate(*goGenerateScope, os.Getenv); err != nil {
+			log.Fatal(err)
+		}
+	} else if flags.Changed("gogenerate-scope") {
+		log.Fatal("--gogenerate-scope requires --gogenerate")
+	} else if flags.NArg() < 1 {
+		log.Fatal("must specify at least one pattern")
+	}
+
+	b, err := loadBackend(*template)
+	if err != nil {
+		log.Fatalf("failed to load template: %v", err)
+	}
+
+	if *coverage != "" || *minCoverage > 0 {
+		if err := reportCoverage(settings, b.inspect, patterns, *coverage, *coverageOutput, *minCoverage); err != nil {
+			log.Fatalf("failed to report coverage: %v", err)
+		}
+
+		return
+	}
+
+	if *failOnDuplicates && *checkFormat == "" {
+		*checkFormat = "text"
+	}
+
+	if *checkFormat != "" {
+		if err := runCheck(settings, b.inspect, patterns, *checkFormat, *checkOutput, *failOnDuplicates); err != nil {
+			log.Fatalf("check failed: %v", err)
+		}
+
+		return
+	}
+
+	if *markers {
+		b.update = tracegen.Markers{Updater: b.update}
+		b.key += " markers"
+	}
+
+	if flags.Arg(0) == "-" {
+		if flags.NArg() > 1 {
+			log.Fatal("cannot specify patterns when reading from stdin")
+		}
+
+		if err := filter(settings, b, *srcdir, os.Stdin, os.Stdout); err != nil {
+			log.Fatalf("failed to process stdin: %v", err)
+		}
+
+		return
+	}
+
+	p := &tracegen.Processor{
+		Settings:    settings,
+		Updater:     b.update,
+		GetResolver: b.getResolver,
+		Jobs:        *jobs,
+		Verify:      *verify,
+	}
+
+	if *cache || *cacheDir != "" {
+		if *cacheDir == "" {
+			if *cacheDir, err = tracegen.DefaultCacheDir(); err != nil {
+				log.Fatalf("failed to open cache: %v", err)
+			}
+		}
+
+		p.Cache = &tracegen.Cache{Dir: *cacheDir, Key: b.key}
+	}
+
+	if *since != "" || *staged {
+		if p.Diff, err = tracegen.GitDiff(".", *since, *staged); err != nil {
+			log.Fatalf("failed to find changes: %v", err)
+		}
+	}
+
+	if generated != nil {
+		p.Diff = generated
+	}
+
+	result, err := p.Process(patterns)
+
+	if result != nil {
+		for _, warning := range result.Warnings {
+			log.Print(warning)
+		}
+	}
+
+	if *report != "" && result != nil {
+		if err := writeReport(result, *report, *reportOutput); err != nil {
+			log.Fatalf("failed to write report: %v", err)
+		}
+	}
+
+	if err != nil {
+		log.Fatalf("failed to process: %v", err)
+	}
+}
+
+func defaultSettings() tracegen.Settings {
+	settings := tracegen.DefaultSettings()
+	settings.Exclude = append(settings.Exclude, `/generated(/|$)`)
+
+	return settings
+}
+
+// parseFlags parses args into flags and settings, exiting if they're invalid
+// or no package patterns were supplied.
+func parseFlags(flags *pflag.FlagSet, settings *tracegen.Settings, args []string) {
+	parseArgs(flags, settings, args)
+
 	if flags.NArg() < 1 {
 		log.Fatal("must specify at least one pattern")
 	}
+}
+
+// parseArgs is like parseFlags, but allows patterns to be omitted.
+func parseArgs(flags *pflag.FlagSet, settings *tracegen.Settings, args []string) {
+	if err := flags.Parse(args); err != nil {
+		log.Fatalf("failed to parse flags: %v", err)
+	}
 
 	if err := settings.Parse(); err != nil {
 		log.Fatalf("failed to parse settings: %v", err)
 	}
+}
 
-	if err := tracegen.Process(settings, flags.Args(), update, getResolver); err != nil {
-		log.Fatalf("failed to process: %v", err)
+// createOutput opens the named file for writing, or stdout if name is empty.
+func createOutput(name string) (io.WriteCloser, error) {
+	if name == "" {
+		return nopCloser{os.Stdout}, nil
 	}
+
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create output")
+	}
+
+	return f, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
 }
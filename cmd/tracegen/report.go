@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Deiz/tracegen"
+)
+
+// checkReportFormat returns an error if format isn't a known report format, so
+// that it can be rejected before anything is processed.
+func checkReportFormat(format string) error {
+	if format != "json" {
+		return fmt.Errorf("unknown report format %q", format)
+	}
+
+	return nil
+}
+
+// writeReport writes a report of a run of tracegen.Process.
+func writeReport(result *tracegen.Result, format string, output string) (err error) {
+	if err := checkReportFormat(format); err != nil {
+		return err
+	}
+
+	w, err := createOutput(output)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	return result.WriteJSON(w)
+}
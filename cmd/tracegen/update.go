@@ -26,13 +26,12 @@ func update(fn *dst.FuncDecl, shouldSkip bool) (imports []string) {
 		imports = []string{"github.com/opentracing/opentracing-go"}
 	}
 
-	params := fn.Type.Params.List
-	if len(params) == 0 || fmt.Sprintf("%s", params[0].Type) != "context.Context" {
+	if !takesContext(fn) {
 		return
 	}
 
 	stmt := getStmt(fn)
-	matched := make([]*int, len(stmt))
+	matched := match(fn)
 
 	getMatched := func() (r [][2]int) {
 		for i, m := range matched {
@@ -107,6 +106,47 @@ func update(fn *dst.FuncDecl, shouldSkip bool) (imports []string) {
 		}
 	}()
 
+	return
+}
+
+// inspect reports whether fn takes a context.Context, and whether the span
+// statements managed by update are already present and up to date.
+func inspect(fn *dst.FuncDecl) (state tracegen.FuncState) {
+	if !takesContext(fn) {
+		return state
+	}
+
+	matched := match(fn)
+
+	state.Eligible = true
+	state.Present = matched[0] != nil && matched[1] != nil
+	state.SpanName = fn.Name.Name
+
+	// The span is named after the function, so it's stale if the function
+	// has since been renamed.
+	if state.Present {
+		call := fn.Body.List[*matched[0]].(*dst.AssignStmt).Rhs[0].(*dst.CallExpr)
+		if len(call.Args) != 2 {
+			state.Stale = true
+		} else if lit, ok := call.Args[1].(*dst.BasicLit); !ok || lit.Value != strconv.Quote(fn.Name.Name) {
+			state.Stale = true
+		}
+	}
+
+	return state
+}
+
+// takesContext reports whether fn has a context.Context as its first parameter.
+func takesContext(fn *dst.FuncDecl) bool {
+	params := fn.Type.Params.List
+	return len(params) > 0 && fmt.Sprintf("%s", params[0].Type) == "context.Context"
+}
+
+// match returns the indices within fn's body of the statements produced by
+// getStmt, with a nil entry for each statement that isn't present.
+func match(fn *dst.FuncDecl) (matched []*int) {
+	matched = make([]*int, 2)
+
 	if fn.Body == nil {
 		return
 	}
@@ -160,7 +200,7 @@ func update(fn *dst.FuncDecl, shouldSkip bool) (imports []string) {
 		}
 	}
 
-	return
+	return matched
 }
 
 func getStmt(fn *dst.FuncDecl) []dst.Stmt {
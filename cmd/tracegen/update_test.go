@@ -103,10 +103,10 @@ func TestUpdater(t *testing.T) {
 			err := os.Chdir(filepath.Dir(path))
 			check(t, err)
 
-			err = tracegen.Process(
+			_, err = tracegen.Process(
 				test.settings,
 				[]string{"."},
-				update,
+				tracegen.UpdateFunc(update),
 				getResolver,
 			)
 			check(t, err)
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Deiz/tracegen/analyzer"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/singlechecker"
+)
+
+// vetAnalyzer reports functions with missing or stale instrumentation. Its
+// flags mirror those of the default command.
+var vetAnalyzer = &analysis.Analyzer{
+	Name: "tracegen",
+	Doc:  analyzer.Doc,
+	Run:  runVet,
+}
+
+var (
+	vetSettings = defaultSettings()
+	vetTemplate string
+
+	vetOnce    sync.Once
+	vetConfig  analyzer.Config
+	vetInitErr error
+)
+
+func init() {
+	vetAnalyzer.Flags.BoolVar(&vetSettings.Exported, "exported", vetSettings.Exported, "if specified, only report exported functions and methods")
+	vetAnalyzer.Flags.BoolVar(&vetSettings.Tagged, "tagged", vetSettings.Tagged, "if specified, only report functions and methods tagged trace:enable")
+	vetAnalyzer.Flags.BoolVar(&vetSettings.Methods, "methods", vetSettings.Methods, "if specified, only report methods")
+	vetAnalyzer.Flags.StringVar(&vetTemplate, "template", "", "if specified, check for the statements defined by this template file rather than opentracing spans")
+}
+
+func runVet(pass *analysis.Pass) (interface{}, error) {
+	// Packages may be analyzed concurrently, but the flags are only parsed
+	// once.
+	vetOnce.Do(func() {
+		if vetInitErr = vetSettings.Parse(); vetInitErr != nil {
+			return
+		}
+
+		var b backend
+		if b, vetInitErr = loadBackend(vetTemplate); vetInitErr != nil {
+			return
+		}
+
+		vetConfig = analyzer.Config{
+			Settings:    vetSettings,
+			Updater:     b.update,
+			Inspect:     b.inspect,
+			GetResolver: b.getResolver,
+		}
+	})
+
+	if vetInitErr != nil {
+		return nil, vetInitErr
+	}
+
+	return nil, analyzer.Run(pass, vetConfig)
+}
+
+// vetCommand runs the analyzer as a standalone checker, supporting -fix to
+// apply its suggested fixes.
+func vetCommand(args []string) {
+	os.Args = append([]string{os.Args[0]}, args...)
+	singlechecker.Main(vetAnalyzer)
+}
+
+// invokedByVet reports whether the arguments are those passed by
+// go vet -vettool, which queries the tool's version and flags before running
+// it on a config file for each package.
+func invokedByVet(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	return strings.HasPrefix(args[0], "-V=") || args[0] == "-flags" || strings.HasSuffix(args[len(args)-1], ".cfg")
+}
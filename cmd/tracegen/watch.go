@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/sha256"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Deiz/tracegen"
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/packages"
+)
+
+// watchCommand updates the matching packages, then polls their files for
+// changes, updating the packages they belong to whenever they're saved.
+func watchCommand(args []string) {
+	settings := defaultSettings()
+	flags := tracegen.DefaultFlags(&settings)
+
+	template := templateFlag(flags)
+	jobs := flags.Int("jobs", runtime.NumCPU(), "the maximum number of packages to process concurrently")
+	markers := flags.Bool("markers", false, "if specified, wrap generated code in tracegen:begin and tracegen:end comments, and leave hand-edited regions untouched")
+	interval := flags.Duration("interval", time.Second, "how often to check for changed files")
+
+	parseFlags(flags, &settings, args)
+
+	b, err := loadBackend(*template)
+	if err != nil {
+		log.Fatalf("failed to load template: %v", err)
+	}
+
+	if *markers {
+		b.update = tracegen.Markers{Updater: b.update}
+	}
+
+	w := newWatcher(&tracegen.Processor{
+		Settings:    settings,
+		Updater:     b.update,
+		GetResolver: b.getResolver,
+		Jobs:        *jobs,
+	}, flags.Args())
+
+	for {
+		// Errors are usually transient, e.g. while a file is half-written
+		if err := w.poll(); err != nil {
+			log.Print(err)
+		}
+
+		time.Sleep(*interval)
+	}
+}
+
+// watcher updates packages as their files change. It isn't safe for
+// concurrent use.
+type watcher struct {
+	processor *tracegen.Processor
+	patterns  []string
+
+	// The state of each file when it was last seen, keyed by filename
+	files *fileStates
+
+	// Whether the files have been scanned before
+	primed bool
+}
+
+// fileState identifies the contents of a file.
+type fileState struct {
+	modTime time.Time
+	size    int64
+	sum     [sha256.Size]byte
+}
+
+// fileStates is safe for concurrent use.
+type fileStates struct {
+	mu    sync.Mutex
+	files map[string]fileState
+}
+
+func (f *fileStates) get(filename string) (state fileState, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	state, ok = f.files[filename]
+	return state, ok
+}
+
+func (f *fileStates) set(filename string, state fileState) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.files[filename] = state
+}
+
+// newWatcher returns a watcher that runs p on the packages matching patterns.
+// p's FS is replaced, so that the watcher sees its own writes.
+func newWatcher(p *tracegen.Processor, patterns []string) *watcher {
+	w := &watcher{
+		processor: p,
+		patterns:  patterns,
+		files:     &fileStates{files: make(map[string]fileState)},
+	}
+
+	p.FS = &watchFS{files: w.files}
+
+	return w
+}
+
+// poll updates the packages whose files have changed since the last poll, or
+// every package on the first poll, logging the changes made.
+func (w *watcher) poll() error {
+	changed, err := w.scan()
+	if err != nil {
+		return err
+	}
+
+	patterns := changed
+	if !w.primed {
+		patterns, w.primed = w.patterns, true
+	}
+
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	result, err := w.processor.Process(patterns)
+	if result != nil {
+		for _, warning := range result.Warnings {
+			log.Print(warning)
+		}
+
+		for _, pkg := range result.Packages {
+			for _, filename := range pkg.Changed {
+				log.Printf("updated %s", filename)
+			}
+
+			for _, filename := range pkg.Deleted {
+				log.Printf("removed %s", filename)
+			}
+		}
+	}
+
+	return err
+}
+
+// scan records the state of the matching packages' files, returning the paths
+// of the packages with files that changed since the last scan. Packages with
+// syntax errors are omitted, as they're likely being edited.
+func (w *watcher) scan() (changed []string, err error) {
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles,
+		Dir:  w.processor.Dir,
+	}
+
+	pkgs, err := packages.Load(cfg, w.patterns...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list packages")
+	}
+
+	for _, pkg := range pkgs {
+		var pkgChanged bool
+
+		for _, filename := range pkg.GoFiles {
+			fileChanged, err := w.update(filename)
+			if err != nil {
+				return nil, err
+			}
+
+			if fileChanged {
+				pkgChanged = true
+			}
+		}
+
+		if !pkgChanged || !w.primed {
+			continue
+		}
+
+		if err := parseFiles(pkg.GoFiles); err != nil {
+			log.Printf("skipping %s: %v", pkg.PkgPath, err)
+			continue
+		}
+
+		changed = append(changed, pkg.PkgPath)
+	}
+
+	sort.Strings(changed)
+
+	return changed, nil
+}
+
+// update records the state of the named file, reporting whether its contents
+// changed since it was last recorded, or were recorded for the first time.
+func (w *watcher) update(filename string) (changed bool, err error) {
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrapf(err, "failed to stat %s", filename)
+	}
+
+	previous, ok := w.files.get(filename)
+	if ok && previous.modTime.Equal(info.ModTime()) && previous.size == info.Size() {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to read %s", filename)
+	}
+
+	state := fileState{modTime: info.ModTime(), size: info.Size(), sum: sha256.Sum256(data)}
+	w.files.set(filename, state)
+
+	// Files that were merely touched, or written by the watcher itself, keep
+	// their checksum
+	return !ok || previous.sum != state.sum, nil
+}
+
+// parseFiles returns the first syntax error in the named files, if any.
+func parseFiles(filenames []string) error {
+	fset := token.NewFileSet()
+
+	for _, filename := range filenames {
+		if _, err := parser.ParseFile(fset, filename, nil, parser.AllErrors); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// watchFS writes to the operating system's filesystem, recording the checksum
+// of each file written so the watcher doesn't react to its own changes.
+type watchFS struct {
+	tracegen.OSFS
+
+	files *fileStates
+}
+
+func (fs *watchFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	if err := fs.OSFS.WriteFile(name, data, perm); err != nil {
+		return err
+	}
+
+	// The next scan finds the file's size and modification time don't match,
+	// but that its checksum does
+	fs.files.set(name, fileState{size: -1, sum: sha256.Sum256(data)})
+
+	return nil
+}
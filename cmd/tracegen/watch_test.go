@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Deiz/tracegen"
+)
+
+func TestWatcher(t *testing.T) {
+	path := writeModule(t, filterInput)
+	dir := filepath.Dir(path)
+
+	template := filepath.Join(dir, "template.go.tmpl")
+	err := os.WriteFile(template, []byte(filterTemplate), 0644)
+	check(t, err)
+
+	b, err := loadBackend(template)
+	check(t, err)
+
+	var runs int
+
+	w := newWatcher(&tracegen.Processor{
+		Settings:    defaultSettings(),
+		Updater:     countingUpdater{b.update, &runs},
+		GetResolver: b.getResolver,
+		Dir:         dir,
+	}, []string{"./..."})
+
+	// expect polls the watcher, checking whether the updater was run and the
+	// contents of the file afterwards
+	expect := func(step string, ran bool, contents string) {
+		t.Helper()
+
+		runs = 0
+		check(t, w.poll())
+
+		if (runs > 0) != ran {
+			t.Fatalf("%s: expected the updater to run: %t, got %d calls", step, ran, runs)
+		}
+
+		data, err := os.ReadFile(path)
+		check(t, err)
+
+		if string(data) != contents {
+			t.Fatalf("%s: mismatched output:\ngot:\n%s\nexpected:\n%s", step, string(data), contents)
+		}
+	}
+
+	expect("initial run", true, filterOutput)
+
+	// The watcher's own write isn't a change
+	expect("after writing", false, filterOutput)
+
+	edited := filterOutput + "\nfunc Bar(ctx int) {}\n"
+	err = os.WriteFile(path, []byte(edited), 0644)
+	check(t, err)
+
+	expect("after an edit", true, filterOutput+"\nfunc Bar(ctx int) {\n\tprintln(ctx, \"Bar\")\n}\n")
+	expect("after converging", false, filterOutput+"\nfunc Bar(ctx int) {\n\tprintln(ctx, \"Bar\")\n}\n")
+
+	// Packages with syntax errors are left alone until they're fixed
+	broken := filterOutput + "\nfunc Baz(ctx int) {\n"
+	err = os.WriteFile(path, []byte(broken), 0644)
+	check(t, err)
+
+	expect("with a syntax error", false, broken)
+}
+
+// countingUpdater counts the calls made to its updater.
+type countingUpdater struct {
+	tracegen.Updater
+	calls *int
+}
+
+func (u countingUpdater) Update(fc *tracegen.FuncContext) (imports []string, err error) {
+	*u.calls++
+	return u.Updater.Update(fc)
+}
@@ -0,0 +1,105 @@
+package tracegen
+
+// Composite applies several updaters to each function in a single pass, so
+// that e.g. tracing, metrics and logging code can coexist.
+//
+// Each member must recognise and manage only its own statements, wherever they
+// appear in the function body. Updaters that insert their statements at the
+// start of the body will find those of earlier members preceding those of
+// later members, as members are invoked in reverse order.
+//
+// The imports of all members are merged. If a member returns an error, the
+// remaining members are not invoked. Hooks are invoked on any members that
+// implement FileHook or PackageHook, in order.
+type Composite []Updater
+
+func (c Composite) Update(fc *FuncContext) (imports []string, err error) {
+	results := make([][]string, len(c))
+
+	for i := len(c) - 1; i >= 0; i-- {
+		results[i], err = c[i].Update(fc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	seen := make(map[string]struct{})
+
+	for _, result := range results {
+		for _, imp := range result {
+			if _, ok := seen[imp]; ok {
+				continue
+			}
+
+			seen[imp] = struct{}{}
+			imports = append(imports, imp)
+		}
+	}
+
+	return imports, nil
+}
+
+// BeforeFile invokes the BeforeFile hooks of any members implementing FileHook.
+func (c Composite) BeforeFile(fc *FileContext) (imports []string, err error) {
+	return c.fileHooks(func(h FileHook) ([]string, error) { return h.BeforeFile(fc) })
+}
+
+// AfterFile invokes the AfterFile hooks of any members implementing FileHook.
+func (c Composite) AfterFile(fc *FileContext) (imports []string, err error) {
+	return c.fileHooks(func(h FileHook) ([]string, error) { return h.AfterFile(fc) })
+}
+
+func (c Composite) fileHooks(call func(h FileHook) ([]string, error)) (imports []string, err error) {
+	for _, u := range c {
+		if h, ok := u.(FileHook); ok {
+			imps, err := call(h)
+			if err != nil {
+				return nil, err
+			}
+
+			imports = append(imports, imps...)
+		}
+	}
+
+	return imports, nil
+}
+
+// BeforePackage invokes the BeforePackage hooks of any members implementing
+// PackageHook.
+func (c Composite) BeforePackage(pc *PackageContext) error {
+	for _, u := range c {
+		if h, ok := u.(PackageHook); ok {
+			if err := h.BeforePackage(pc); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// AfterPackage invokes the AfterPackage hooks of any members implementing
+// PackageHook.
+func (c Composite) AfterPackage(pc *PackageContext) error {
+	for _, u := range c {
+		if h, ok := u.(PackageHook); ok {
+			if err := h.AfterPackage(pc); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// NeedsTypes reports whether any member implementing TypesUser needs packages
+// to be type-checked.
+func (c Composite) NeedsTypes() bool {
+	for _, u := range c {
+		if needsTypes(u) {
+			return true
+		}
+	}
+
+	return false
+}
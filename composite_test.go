@@ -0,0 +1,116 @@
+package tracegen
+
+import (
+	"errors"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver"
+)
+
+const compositeInput = `package main
+
+func Foo() {
+	println("body")
+}
+`
+
+const compositeOutput = `package main
+
+func Foo() {
+	println("first")
+	println("second")
+	println("body")
+}
+`
+
+// printlnUpdater manages a leading println of its name, returning imports as
+// its only import.
+type printlnUpdater struct {
+	name    string
+	imports []string
+}
+
+func (u printlnUpdater) Update(fc *FuncContext) (imports []string, err error) {
+	body := fc.Func.Body
+
+	for i, stmt := range body.List {
+		if expr, ok := stmt.(*dst.ExprStmt); ok {
+			if call, ok := expr.X.(*dst.CallExpr); ok && len(call.Args) == 1 {
+				if lit, ok := call.Args[0].(*dst.BasicLit); ok && lit.Value == strconv.Quote(u.name) {
+					body.List = append(body.List[:i], body.List[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+
+	stmt := &dst.ExprStmt{
+		X: &dst.CallExpr{
+			Fun:  dst.NewIdent("println"),
+			Args: []dst.Expr{&dst.BasicLit{Kind: token.STRING, Value: strconv.Quote(u.name)}},
+		},
+		Decs: dst.ExprStmtDecorations{NodeDecs: dst.NodeDecs{Before: dst.NewLine, After: dst.NewLine}},
+	}
+
+	body.List = append([]dst.Stmt{stmt}, body.List...)
+
+	return u.imports, nil
+}
+
+func TestComposite(t *testing.T) {
+	path := writeModule(t, compositeInput)
+	err := os.Chdir(filepath.Dir(path))
+	check(t, err)
+
+	update := Composite{printlnUpdater{name: "first"}, printlnUpdater{name: "second"}}
+
+	getResolver := func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+		return NewSimpleResolver(pkg, file, nil)
+	}
+
+	// Running twice checks the members don't disturb each other's statements
+	for i := 0; i < 2; i++ {
+		_, err = Process(Settings{}, []string{"."}, update, getResolver)
+		check(t, err)
+
+		data, err := os.ReadFile(path)
+		check(t, err)
+
+		if string(data) != compositeOutput {
+			t.Fatalf("mismatched output on run %d:\ngot:\n%s\nexpected:\n%s", i+1, string(data), compositeOutput)
+		}
+	}
+}
+
+type errUpdater struct{}
+
+func (errUpdater) Update(fc *FuncContext) (imports []string, err error) {
+	return nil, errors.New("failed")
+}
+
+func TestCompositeImports(t *testing.T) {
+	fc := &FuncContext{Func: &dst.FuncDecl{Name: dst.NewIdent("Foo"), Body: &dst.BlockStmt{}}}
+
+	update := Composite{
+		printlnUpdater{name: "a", imports: []string{"example.com/a", "example.com/shared"}},
+		printlnUpdater{name: "b", imports: []string{"example.com/b", "example.com/shared"}},
+	}
+
+	imports, err := update.Update(fc)
+	check(t, err)
+
+	if expected := []string{"example.com/a", "example.com/shared", "example.com/b"}; !reflect.DeepEqual(imports, expected) {
+		t.Fatalf("mismatched imports, got %v, expected %v", imports, expected)
+	}
+
+	if _, err := append(update, errUpdater{}).Update(fc); err == nil {
+		t.Fatal("expected the member's error to be returned")
+	}
+}
@@ -0,0 +1,353 @@
+package tracegen
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// FuncState describes the instrumentation of a single function, as reported
+// by an Inspector.
+type FuncState struct {
+	// Eligible is true if the function is a candidate for instrumentation,
+	// e.g. because it takes a context.Context.
+	Eligible bool
+
+	// Present is true if the function's instrumentation is already in place.
+	Present bool
+
+	// Stale is true if the instrumentation is present but no longer matches
+	// what the updater would generate, e.g. due to a renamed function.
+	Stale bool
+
+	// SpanName is the name of the span the updater generates for the
+	// function, if any.
+	SpanName string
+}
+
+// An Inspector reports on the current instrumentation of a function without
+// modifying it. It is the read-only counterpart of an updater, and should
+// recognise the same statements the updater manages.
+type Inspector func(fn *dst.FuncDecl) FuncState
+
+// Coverage records which eligible functions are instrumented, overall and per
+// package. Functions that tracegen has decided to skip are listed, but do not
+// count towards the totals.
+type Coverage struct {
+	Packages []*PackageCoverage `json:"packages"`
+
+	Total   int     `json:"total"`
+	Traced  int     `json:"traced"`
+	Percent float64 `json:"percent"`
+}
+
+type PackageCoverage struct {
+	Path  string          `json:"path"`
+	Funcs []*FuncCoverage `json:"funcs"`
+
+	Total   int     `json:"total"`
+	Traced  int     `json:"traced"`
+	Percent float64 `json:"percent"`
+}
+
+type FuncCoverage struct {
+	Name     string `json:"name"`
+	Receiver string `json:"receiver,omitempty"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	EndLine  int    `json:"end_line"`
+	Skipped  bool   `json:"skipped"`
+	Traced   bool   `json:"traced"`
+
+	offset, endOffset int
+}
+
+// MeasureCoverage inspects every function in the supplied packages, applying
+// the same skip decisions as ProcessPackages, and reports how many of the
+// eligible functions are instrumented.
+func MeasureCoverage(settings Settings, pkgs []*decorator.Package, inspect Inspector) (c *Coverage, err error) {
+	c = &Coverage{}
+
+	for _, pkg := range pkgs {
+		if _, ok := excluded(settings, pkg); ok {
+			continue
+		}
+
+		pc := &PackageCoverage{Path: pkg.PkgPath}
+
+		inspectFuncs(settings, pkg, func(file *dst.File, node *dst.FuncDecl, shouldSkip bool) {
+			state := inspect(node)
+			if !state.Eligible {
+				return
+			}
+
+			start, end := position(pkg, node)
+
+			fc := &FuncCoverage{
+				Name:      node.Name.Name,
+				Receiver:  receiverName(node),
+				File:      start.Filename,
+				Line:      start.Line,
+				Column:    start.Column,
+				EndLine:   end.Line,
+				Skipped:   shouldSkip,
+				Traced:    state.Present,
+				offset:    start.Offset,
+				endOffset: end.Offset,
+			}
+
+			pc.Funcs = append(pc.Funcs, fc)
+
+			if !fc.Skipped {
+				pc.Total++
+				if fc.Traced {
+					pc.Traced++
+				}
+			}
+		})
+
+		if len(pc.Funcs) == 0 {
+			continue
+		}
+
+		pc.Percent = percent(pc.Traced, pc.Total)
+
+		c.Packages = append(c.Packages, pc)
+		c.Total += pc.Total
+		c.Traced += pc.Traced
+	}
+
+	c.Percent = percent(c.Traced, c.Total)
+
+	return c, nil
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+
+	return float64(n) * 100 / float64(total)
+}
+
+// WriteText writes a per-function listing followed by per-package and overall
+// totals, in the spirit of `go tool cover -func`.
+func (c *Coverage) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, '\t', 0)
+
+	for _, pc := range c.Packages {
+		for _, fc := range pc.Funcs {
+			status := "missing"
+			if fc.Skipped {
+				status = "skipped"
+			} else if fc.Traced {
+				status = "traced"
+			}
+
+			fmt.Fprintf(tw, "%s:%d:\t%s\t%s\n", relPath(fc.File), fc.Line, fc.qualifiedName(), status)
+		}
+	}
+
+	for _, pc := range c.Packages {
+		fmt.Fprintf(tw, "%s\t\t%.1f%% (%d/%d)\n", pc.Path, pc.Percent, pc.Traced, pc.Total)
+	}
+
+	fmt.Fprintf(tw, "total:\t\t%.1f%% (%d/%d)\n", c.Percent, c.Traced, c.Total)
+
+	return tw.Flush()
+}
+
+// WriteJSON writes the coverage as an indented JSON document.
+func (c *Coverage) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(c)
+}
+
+// WriteHTML writes a self-contained HTML page showing the source of every file
+// with eligible functions, highlighting traced, missing and skipped functions.
+func (c *Coverage) WriteHTML(w io.Writer) error {
+	var files []htmlFile
+
+	for _, pc := range c.Packages {
+		byFile := make(map[string][]*FuncCoverage)
+
+		var names []string
+		for _, fc := range pc.Funcs {
+			if _, ok := byFile[fc.File]; !ok {
+				names = append(names, fc.File)
+			}
+			byFile[fc.File] = append(byFile[fc.File], fc)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			src, err := os.ReadFile(name)
+			if err != nil {
+				return err
+			}
+
+			funcs := byFile[name]
+			sort.Slice(funcs, func(i, j int) bool { return funcs[i].offset < funcs[j].offset })
+
+			var traced, total int
+			for _, fc := range funcs {
+				if !fc.Skipped {
+					total++
+					if fc.Traced {
+						traced++
+					}
+				}
+			}
+
+			files = append(files, htmlFile{
+				Name:    filepath.Join(pc.Path, filepath.Base(name)),
+				Percent: percent(traced, total),
+				Body:    annotate(src, funcs),
+			})
+		}
+	}
+
+	return htmlTemplate.Execute(w, struct {
+		Coverage *Coverage
+		Files    []htmlFile
+	}{c, files})
+}
+
+type htmlFile struct {
+	Name    string
+	Percent float64
+	Body    template.HTML
+}
+
+// annotate escapes src, wrapping each function in a span styled according to
+// its coverage.
+func annotate(src []byte, funcs []*FuncCoverage) template.HTML {
+	var buf strings.Builder
+
+	last := 0
+	for _, fc := range funcs {
+		if fc.offset < last || fc.endOffset > len(src) {
+			continue
+		}
+
+		class := "missing"
+		if fc.Skipped {
+			class = "skipped"
+		} else if fc.Traced {
+			class = "traced"
+		}
+
+		buf.WriteString(template.HTMLEscapeString(string(src[last:fc.offset])))
+		fmt.Fprintf(&buf, `<span class="%s" title="%s">`, class, class)
+		buf.WriteString(template.HTMLEscapeString(string(src[fc.offset:fc.endOffset])))
+		buf.WriteString(`</span>`)
+
+		last = fc.endOffset
+	}
+
+	buf.WriteString(template.HTMLEscapeString(string(src[last:])))
+
+	return template.HTML(buf.String())
+}
+
+var htmlTemplate = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<title>tracegen coverage</title>
+<style>
+body { background: black; color: rgb(80, 80, 80); }
+body, pre, #legend span { font-family: Menlo, monospace; font-weight: bold; }
+#topbar { background: black; position: fixed; top: 0; left: 0; right: 0; height: 42px; border-bottom: 1px solid rgb(80, 80, 80); }
+#content { margin-top: 50px; }
+#nav, #legend { float: left; margin-left: 10px; }
+#legend { margin-top: 12px; }
+#nav { margin-top: 10px; }
+#legend span { margin: 0 5px; }
+.traced { color: rgb(44, 212, 149); }
+.missing { color: rgb(192, 0, 0); }
+.skipped { color: rgb(128, 128, 128); }
+</style>
+</head>
+<body>
+<div id="topbar">
+<div id="nav">
+<select id="files">
+{{range $i, $f := .Files}}<option value="file{{$i}}">{{$f.Name}} ({{printf "%.1f" $f.Percent}}%)</option>
+{{end}}</select>
+</div>
+<div id="legend">
+<span>total: {{printf "%.1f" .Coverage.Percent}}% ({{.Coverage.Traced}}/{{.Coverage.Total}})</span>
+<span class="traced">traced</span>
+<span class="missing">missing</span>
+<span class="skipped">skipped</span>
+</div>
+</div>
+<div id="content">
+{{range $i, $f := .Files}}<pre class="file" id="file{{$i}}" style="display: none">{{$f.Body}}</pre>
+{{end}}</div>
+<script>
+(function() {
+	var files = document.getElementById('files');
+	var visible;
+	files.addEventListener('change', onChange, false);
+	function select(part) {
+		if (visible)
+			visible.style.display = 'none';
+		visible = document.getElementById(part);
+		if (!visible)
+			return;
+		files.value = part;
+		visible.style.display = 'block';
+		location.hash = part;
+	}
+	function onChange() {
+		select(files.value);
+		window.scrollTo(0, 0);
+	}
+	if (location.hash != "") {
+		select(location.hash.substr(1));
+	}
+	if (!visible) {
+		select("file0");
+	}
+})();
+</script>
+</body>
+</html>
+`))
+
+func (fc *FuncCoverage) qualifiedName() string {
+	if fc.Receiver != "" {
+		return fc.Receiver + "." + fc.Name
+	}
+
+	return fc.Name
+}
+
+// relPath returns path relative to the working directory where possible.
+func relPath(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+
+	if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+
+	return path
+}
@@ -0,0 +1,83 @@
+package tracegen
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+)
+
+const coverageInput = `package main
+
+func A(x int) {
+	println(x)
+}
+
+func B(x int) {}
+
+//trace:skip
+func C(x int) {}
+
+func D() {}
+`
+
+// inspectBody treats functions with parameters as eligible, and non-empty
+// bodies as instrumented.
+func inspectBody(fn *dst.FuncDecl) (state FuncState) {
+	state.Eligible = len(fn.Type.Params.List) > 0
+	state.Present = fn.Body != nil && len(fn.Body.List) > 0
+
+	return state
+}
+
+func TestMeasureCoverage(t *testing.T) {
+	path := writeModule(t, coverageInput)
+	err := os.Chdir(filepath.Dir(path))
+	check(t, err)
+
+	pkgs, err := LoadPackages([]string{"."})
+	check(t, err)
+
+	c, err := MeasureCoverage(Settings{}, pkgs, inspectBody)
+	check(t, err)
+
+	if c.Total != 2 || c.Traced != 1 || c.Percent != 50 {
+		t.Fatalf("unexpected totals, got %d/%d (%.1f%%), expected 1/2 (50.0%%)", c.Traced, c.Total, c.Percent)
+	}
+
+	if len(c.Packages) != 1 || len(c.Packages[0].Funcs) != 3 {
+		t.Fatalf("expected a single package with 3 eligible functions, got %+v", c.Packages)
+	}
+
+	var statuses []string
+	for _, fc := range c.Packages[0].Funcs {
+		statuses = append(statuses, fc.Name)
+		if fc.Skipped {
+			statuses = append(statuses, "skipped")
+		} else if fc.Traced {
+			statuses = append(statuses, "traced")
+		}
+	}
+
+	if got, expected := strings.Join(statuses, " "), "A traced B C skipped"; got != expected {
+		t.Fatalf("mismatched functions, got %q, expected %q", got, expected)
+	}
+
+	buf := &bytes.Buffer{}
+	check(t, c.WriteText(buf))
+
+	if !regexp.MustCompile(`total:\s+50\.0% \(1/2\)`).MatchString(buf.String()) {
+		t.Fatalf("missing total in text output:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	check(t, c.WriteHTML(buf))
+
+	if !strings.Contains(buf.String(), `<span class="missing" title="missing">func B(x int) {}</span>`) {
+		t.Fatalf("missing annotated function in html output:\n%s", buf.String())
+	}
+}
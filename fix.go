@@ -0,0 +1,265 @@
+package tracegen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver"
+	"github.com/pkg/errors"
+)
+
+// A Fix brings a single function's instrumentation up to date. It describes
+// the problem in the same way as Check.
+type Fix struct {
+	Finding
+
+	// Pos is the position of the function within the package's file set.
+	Pos token.Pos
+
+	Edits []Edit
+}
+
+// An Edit replaces the text between Pos and End, which are positions within
+// the package's file set, with NewText.
+type Edit struct {
+	Pos, End token.Pos
+	NewText  []byte
+}
+
+// Fixes returns a fix for each function in pkg whose instrumentation Check
+// would report as missing, stale or present despite the function being
+// skipped. Each fix is computed by applying the updater to that function alone,
+// within a copy of its file, and consists of edits to the function and to the
+// file's imports. pkg is not modified, and hooks are not invoked.
+func Fixes(settings Settings, pkg *decorator.Package, update Updater, inspect Inspector, getResolver func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver) (fixes []Fix, err error) {
+	if _, ok := excluded(settings, pkg); ok {
+		return nil, nil
+	}
+
+	decider := newDecider(settings, pkg.Syntax)
+
+	for _, file := range pkg.Syntax {
+		// The index of each function among the file's functions
+		var index int
+
+		for _, decl := range file.Decls {
+			node, ok := decl.(*dst.FuncDecl)
+			if !ok {
+				continue
+			}
+
+			index++
+
+			state := inspect(node)
+			if !state.Eligible {
+				continue
+			}
+
+			rule, message, ok := checkFunc(node, state, decider.shouldSkip(node))
+			if !ok {
+				continue
+			}
+
+			start, _ := position(pkg, node)
+
+			edits, err := editFunc(pkg, file, index-1, decider, update, getResolver, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			fixes = append(fixes, Fix{
+				Finding: newFinding(rule, message, start),
+				Pos:     pkg.Decorator.Ast.Nodes[node].Pos(),
+				Edits:   edits,
+			})
+		}
+	}
+
+	return fixes, nil
+}
+
+// FuncAt returns the function declaration enclosing pos, which is a position
+// within pkg's file set, along with the file containing it. It returns nil if
+// there is no such function.
+func FuncAt(pkg *decorator.Package, pos token.Pos) (file *dst.File, fn *dst.FuncDecl) {
+	for _, f := range pkg.Syntax {
+		for _, decl := range funcDecls(f.Decls) {
+			n, ok := pkg.Decorator.Ast.Nodes[decl].(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+
+			start := n.Pos()
+			if n.Doc != nil {
+				start = n.Doc.Pos()
+			}
+
+			if start <= pos && pos <= n.End() {
+				return f, decl
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// EditFunc applies the updater to fn, one of pkg's functions, within a copy of
+// its file, and returns the edits needed to bring pkg's file in line with the
+// copy. If prepare is non-nil, it's called before the updater, and may modify
+// the function or its context, e.g. to override the skip decision or to add a
+// directive. pkg is not modified, and hooks are not invoked.
+func EditFunc(settings Settings, pkg *decorator.Package, fn *dst.FuncDecl, update Updater, getResolver func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver, prepare func(fc *FuncContext)) ([]Edit, error) {
+	for _, file := range pkg.Syntax {
+		for i, decl := range funcDecls(file.Decls) {
+			if decl == fn {
+				return editFunc(pkg, file, i, newDecider(settings, pkg.Syntax), update, getResolver, prepare)
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("function %s is not in package %s", funcName(fn), pkg.PkgPath)
+}
+
+// editFunc applies the updater to the index'th function of a copy of file,
+// and returns the edits needed to bring the original file in line with the
+// copy.
+func editFunc(pkg *decorator.Package, file *dst.File, index int, decider *decider, update Updater, getResolver func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver, prepare func(fc *FuncContext)) ([]Edit, error) {
+	original, ok := pkg.Decorator.Ast.Nodes[file].(*ast.File)
+	if !ok {
+		return nil, errors.New("file has no syntax tree")
+	}
+
+	// Decorating the file again gives a copy that can be modified, along
+	// with a mapping back to the original syntax tree.
+	copied := *pkg
+	copied.Decorator = decorator.NewDecoratorWithImports(pkg.Fset, pkg.PkgPath, importResolver(pkg.Package))
+
+	clone, err := copied.Decorator.DecorateFile(original)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to copy file")
+	}
+
+	copied.Decorator.Filenames[clone] = pkg.Decorator.Filenames[file]
+
+	node := funcDecls(clone.Decls)[index]
+	fc := newFuncContext(&copied, clone, node, decider)
+
+	if prepare != nil {
+		prepare(fc)
+	}
+
+	imports, err := update.Update(fc)
+	if err != nil {
+		return nil, &UpdateError{Pos: fc.Position(), Func: funcName(node), Err: err}
+	}
+
+	if !fc.ShouldSkip {
+		for _, imp := range imports {
+			addImport(&copied, clone, imp)
+		}
+	}
+
+	data, err := fileContents(&copied, clone, getResolver(&copied, clone))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to render %s", fc.Filename)
+	}
+
+	fset := token.NewFileSet()
+
+	updated, err := parser.ParseFile(fset, fc.Filename, data, parser.ParseComments)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse updated %s", fc.Filename)
+	}
+
+	// text returns the updated file's source between pos and end
+	text := func(pos, end token.Pos) []byte {
+		return data[fset.Position(pos).Offset:fset.Position(end).Offset]
+	}
+
+	var edits []Edit
+
+	if !equalImports(original, updated) {
+		oldStart, oldEnd, oldOK := importRange(original)
+		newStart, newEnd, _ := importRange(updated)
+
+		switch {
+		case !oldOK:
+			newText := append([]byte("\n\n"), text(newStart, newEnd)...)
+			edits = append(edits, Edit{Pos: original.Name.End(), End: original.Name.End(), NewText: newText})
+		case len(updated.Imports) == 0:
+			edits = append(edits, Edit{Pos: oldStart, End: oldEnd})
+		default:
+			edits = append(edits, Edit{Pos: oldStart, End: oldEnd, NewText: text(newStart, newEnd)})
+		}
+	}
+
+	// The function, including its doc comment
+	oldFunc := astFuncDecls(original)[index]
+	newFunc := astFuncDecls(updated)[index]
+
+	oldStart, newStart := oldFunc.Pos(), newFunc.Pos()
+	if oldFunc.Doc != nil {
+		oldStart = oldFunc.Doc.Pos()
+	}
+	if newFunc.Doc != nil {
+		newStart = newFunc.Doc.Pos()
+	}
+
+	edits = append(edits, Edit{Pos: oldStart, End: oldFunc.End(), NewText: text(newStart, newFunc.End())})
+
+	return edits, nil
+}
+
+func funcDecls(decls []dst.Decl) (funcs []*dst.FuncDecl) {
+	for _, decl := range decls {
+		if fn, ok := decl.(*dst.FuncDecl); ok {
+			funcs = append(funcs, fn)
+		}
+	}
+
+	return funcs
+}
+
+func astFuncDecls(file *ast.File) (funcs []*ast.FuncDecl) {
+	for _, decl := range file.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok {
+			funcs = append(funcs, fn)
+		}
+	}
+
+	return funcs
+}
+
+// equalImports reports whether a and b have the same imports.
+func equalImports(a, b *ast.File) bool {
+	if len(a.Imports) != len(b.Imports) {
+		return false
+	}
+
+	for i := range a.Imports {
+		if a.Imports[i].Path.Value != b.Imports[i].Path.Value || a.Imports[i].Name.String() != b.Imports[i].Name.String() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// importRange returns the range of file's import declarations.
+func importRange(file *ast.File) (start, end token.Pos, ok bool) {
+	for _, decl := range file.Decls {
+		if gen, isGen := decl.(*ast.GenDecl); isGen && gen.Tok == token.IMPORT {
+			if !ok {
+				start, ok = gen.Pos(), true
+			}
+
+			end = gen.End()
+		}
+	}
+
+	return start, end, ok
+}
@@ -0,0 +1,111 @@
+package tracegen
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver"
+)
+
+const fixTemplate = `package template
+
+import "example.com/trace"
+
+func _(__ctx__ int) {
+	defer trace.Start(__ctx__, "__span__")
+}
+`
+
+const fixInput = `package main
+
+func Foo(ctx int) {
+	println("body")
+}
+
+// Unrelated code is left alone
+var  x   int
+
+func Baz(s string) {}
+`
+
+const fixOutput = `package main
+
+import "example.com/trace"
+
+func Foo(ctx int) {
+	defer trace.Start(ctx, "Foo")
+
+	println("body")
+}
+
+// Unrelated code is left alone
+var  x   int
+
+func Baz(s string) {}
+`
+
+func TestFixes(t *testing.T) {
+	update, err := NewTemplateUpdater([]byte(fixTemplate))
+	check(t, err)
+
+	path := writeModule(t, fixInput)
+	err = os.Chdir(filepath.Dir(path))
+	check(t, err)
+
+	pkgs, err := LoadPackages([]string{"."})
+	check(t, err)
+
+	getResolver := func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+		return NewSimpleResolver(pkg, file, update.Hints())
+	}
+
+	fixes, err := Fixes(Settings{}, pkgs[0], update, update.Inspect, getResolver)
+	check(t, err)
+
+	if len(fixes) != 1 || fixes[0].Rule != RuleMissing {
+		t.Fatalf("expected a single missing fix, got %+v", fixes)
+	}
+
+	if output := applyEdits(t, pkgs[0], []byte(fixInput), fixes[0].Edits); output != fixOutput {
+		t.Fatalf("mismatched output:\ngot:\n%s\nexpected:\n%s", output, fixOutput)
+	}
+}
+
+// applyEdits applies edits, whose positions are within pkg's file set, to src.
+func applyEdits(t *testing.T, pkg *decorator.Package, src []byte, edits []Edit) string {
+	t.Helper()
+
+	edits = append([]Edit(nil), edits...)
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].Pos > edits[j].Pos
+	})
+
+	for _, e := range edits {
+		start, end := pkg.Fset.Position(e.Pos).Offset, pkg.Fset.Position(e.End).Offset
+		src = append(append(append([]byte(nil), src[:start]...), e.NewText...), src[end:]...)
+	}
+
+	return string(src)
+}
+
+func TestEditFuncPackageNameMismatch(t *testing.T) {
+	path := writeMismatchedModule(t)
+
+	pkgs, err := (&Processor{Dir: filepath.Dir(path)}).Load([]string{"."})
+	check(t, err)
+
+	fn := funcDecls(pkgs[0].Syntax[0].Decls)[0]
+
+	edits, err := EditFunc(Settings{}, pkgs[0], fn, printlnUpdater{name: "traced"}, func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+		return NewSimpleResolver(pkg, file, nil)
+	}, nil)
+	check(t, err)
+
+	if output := applyEdits(t, pkgs[0], []byte(mismatchInput), edits); output != mismatchOutput {
+		t.Fatalf("mismatched output:\ngot:\n%s\nexpected:\n%s", output, mismatchOutput)
+	}
+}
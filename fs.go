@@ -0,0 +1,245 @@
+package tracegen
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FS is the filesystem a Processor loads packages from and writes changes to.
+// Implementations must be safe for concurrent use.
+type FS interface {
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	Remove(name string) error
+
+	// Overlay returns the contents of any files that should be loaded in
+	// place of those on disk, keyed by absolute filename.
+	Overlay() map[string][]byte
+}
+
+// OSFS is the operating system's filesystem.
+type OSFS struct{}
+
+func (OSFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (OSFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFS) Overlay() map[string][]byte {
+	return nil
+}
+
+// MemFS is an in-memory filesystem. Its files are loaded as an overlay on top
+// of the disk, and files it doesn't hold are read from the disk, but writes and
+// removals only affect memory. Packages are still discovered by the go command,
+// so the directory patterns are resolved in must exist on disk, but it may be
+// empty if MemFS holds the go.mod file.
+//
+// Removing a file records a tombstone, so that it can no longer be read, even
+// if it exists on disk. Such files are still loaded from disk, though, as an
+// overlay can't hide them.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string][]byte
+
+	// Removed files, which may still exist on disk
+	removed map[string]bool
+}
+
+// NewMemFS returns an in-memory filesystem holding a copy of files, which are
+// keyed by filename. Relative filenames are made absolute.
+func NewMemFS(files map[string][]byte) *MemFS {
+	m := &MemFS{
+		files:   make(map[string][]byte, len(files)),
+		removed: make(map[string]bool),
+	}
+
+	for name, data := range files {
+		m.files[absPath(name)] = append([]byte(nil), data...)
+	}
+
+	return m
+}
+
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	data, ok := m.files[absPath(name)]
+	removed := m.removed[absPath(name)]
+	m.mu.Unlock()
+
+	if removed {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	if !ok {
+		// Like the loader, fall back to the file on disk
+		return os.ReadFile(name)
+	}
+
+	return append([]byte(nil), data...), nil
+}
+
+func (m *MemFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name = absPath(name)
+
+	m.files[name] = append([]byte(nil), data...)
+	delete(m.removed, name)
+
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name = absPath(name)
+
+	if _, ok := m.files[name]; !ok {
+		if _, err := os.Stat(name); m.removed[name] || err != nil {
+			return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+		}
+	}
+
+	delete(m.files, name)
+	m.removed[name] = true
+
+	return nil
+}
+
+func (m *MemFS) Overlay() map[string][]byte {
+	return m.Files()
+}
+
+// Files returns a copy of the filesystem's contents, keyed by absolute
+// filename.
+func (m *MemFS) Files() map[string][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	files := make(map[string][]byte, len(m.files))
+	for name, data := range m.files {
+		files[name] = append([]byte(nil), data...)
+	}
+
+	return files
+}
+
+// OverlayFS layers in-memory files, such as unsaved editor buffers, over the
+// disk. Writes and removals are recorded in memory, and are available from
+// Changes rather than being applied to the disk.
+type OverlayFS struct {
+	mu      sync.Mutex
+	overlay map[string][]byte
+
+	// Written files, with removed files mapped to nil
+	changes map[string][]byte
+}
+
+// NewOverlayFS returns a filesystem that reads a copy of overlay in place of
+// the disk, where overlay is keyed by filename. Relative filenames are made
+// absolute.
+func NewOverlayFS(overlay map[string][]byte) *OverlayFS {
+	o := &OverlayFS{
+		overlay: make(map[string][]byte, len(overlay)),
+		changes: make(map[string][]byte),
+	}
+
+	for name, data := range overlay {
+		o.overlay[absPath(name)] = append([]byte(nil), data...)
+	}
+
+	return o
+}
+
+func (o *OverlayFS) ReadFile(name string) ([]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	name = absPath(name)
+
+	if data, ok := o.changes[name]; ok {
+		if data == nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+
+		return append([]byte(nil), data...), nil
+	}
+
+	if data, ok := o.overlay[name]; ok {
+		return append([]byte(nil), data...), nil
+	}
+
+	return os.ReadFile(name)
+}
+
+func (o *OverlayFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.changes[absPath(name)] = append([]byte{}, data...)
+
+	return nil
+}
+
+func (o *OverlayFS) Remove(name string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.changes[absPath(name)] = nil
+
+	return nil
+}
+
+func (o *OverlayFS) Overlay() map[string][]byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	files := make(map[string][]byte, len(o.overlay)+len(o.changes))
+	for name, data := range o.overlay {
+		files[name] = data
+	}
+
+	for name, data := range o.changes {
+		if data != nil {
+			files[name] = data
+		} else {
+			delete(files, name)
+		}
+	}
+
+	return files
+}
+
+// Changes returns the contents of the files written since the filesystem was
+// created, keyed by absolute filename. Removed files are mapped to nil.
+func (o *OverlayFS) Changes() map[string][]byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	changes := make(map[string][]byte, len(o.changes))
+	for name, data := range o.changes {
+		changes[name] = data
+	}
+
+	return changes
+}
+
+func absPath(name string) string {
+	if abs, err := filepath.Abs(name); err == nil {
+		return abs
+	}
+
+	return name
+}
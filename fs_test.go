@@ -0,0 +1,160 @@
+package tracegen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver"
+)
+
+func TestProcessorMemFS(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	check(t, err)
+
+	path := filepath.Join(dir, "sample.go")
+
+	mem := NewMemFS(map[string][]byte{
+		filepath.Join(dir, "go.mod"): []byte(gomod),
+		path:                         []byte(compositeInput),
+	})
+
+	p := &Processor{
+		Updater: Composite{printlnUpdater{name: "first"}, printlnUpdater{name: "second"}},
+		GetResolver: func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+			return NewSimpleResolver(pkg, file, nil)
+		},
+		FS:  mem,
+		Dir: dir,
+	}
+
+	// The second run loads the output of the first from memory
+	for i := 0; i < 2; i++ {
+		result, err := p.Process([]string{"."})
+		check(t, err)
+
+		if changed := len(result.Changed()); changed != 1-i {
+			t.Fatalf("expected %d changed files on run %d, got %d", 1-i, i+1, changed)
+		}
+
+		data, err := mem.ReadFile(path)
+		check(t, err)
+
+		if string(data) != compositeOutput {
+			t.Fatalf("mismatched output on run %d:\ngot:\n%s\nexpected:\n%s", i+1, string(data), compositeOutput)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	check(t, err)
+
+	if len(entries) != 0 {
+		t.Fatalf("expected nothing to be written to disk, found %d files", len(entries))
+	}
+}
+
+func TestProcessorMemFSDiskFiles(t *testing.T) {
+	path := writeModule(t, compositeInput)
+	dir := filepath.Dir(path)
+
+	mem := NewMemFS(nil)
+
+	p := &Processor{
+		Updater: Composite{printlnUpdater{name: "first"}, printlnUpdater{name: "second"}},
+		GetResolver: func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+			return NewSimpleResolver(pkg, file, nil)
+		},
+		FS:    mem,
+		Dir:   dir,
+		Cache: &Cache{Dir: t.TempDir(), Key: "test"},
+	}
+
+	// The file is only on disk, so it's read from there, but written to memory
+	result, err := p.Process([]string{"."})
+	check(t, err)
+
+	if changed := result.Changed(); len(changed) != 1 || changed[0] != path {
+		t.Fatalf("expected %s to change, got %v", path, changed)
+	}
+
+	data, err := mem.ReadFile(path)
+	check(t, err)
+
+	if string(data) != compositeOutput {
+		t.Fatalf("mismatched output:\ngot:\n%s\nexpected:\n%s", string(data), compositeOutput)
+	}
+
+	data, err = os.ReadFile(path)
+	check(t, err)
+
+	if string(data) != compositeInput {
+		t.Fatalf("expected the file on disk to be untouched, got:\n%s", string(data))
+	}
+}
+
+func TestMemFS(t *testing.T) {
+	mem := NewMemFS(nil)
+
+	if _, err := mem.ReadFile("missing.go"); !os.IsNotExist(err) {
+		t.Fatalf("expected a not-exist error, got %v", err)
+	}
+
+	check(t, mem.WriteFile("a.go", []byte("a"), 0644))
+
+	data, err := mem.ReadFile("a.go")
+	check(t, err)
+
+	if string(data) != "a" {
+		t.Fatalf("mismatched contents, got %q", string(data))
+	}
+
+	abs, err := filepath.Abs("a.go")
+	check(t, err)
+
+	if _, ok := mem.Overlay()[abs]; !ok {
+		t.Fatalf("expected %s in the overlay", abs)
+	}
+
+	check(t, mem.Remove("a.go"))
+
+	if err := mem.Remove("a.go"); !os.IsNotExist(err) {
+		t.Fatalf("expected a not-exist error, got %v", err)
+	}
+}
+
+func TestProcessOverlay(t *testing.T) {
+	path := writeModule(t, compositeInput)
+	err := os.Chdir(filepath.Dir(path))
+	check(t, err)
+
+	// The unsaved buffer renames the function
+	buffer := strings.Replace(compositeInput, "Foo", "Bar", 1)
+	expected := strings.Replace(compositeOutput, "Foo", "Bar", 1)
+
+	update := Composite{printlnUpdater{name: "first"}, printlnUpdater{name: "second"}}
+
+	getResolver := func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+		return NewSimpleResolver(pkg, file, nil)
+	}
+
+	_, contents, err := ProcessOverlay(Settings{}, []string{"."}, map[string][]byte{"sample.go": []byte(buffer)}, update, getResolver)
+	check(t, err)
+
+	if len(contents) != 1 {
+		t.Fatalf("expected 1 changed file, got %d", len(contents))
+	}
+
+	if data := string(contents[path]); data != expected {
+		t.Fatalf("mismatched output:\ngot:\n%s\nexpected:\n%s", data, expected)
+	}
+
+	data, err := os.ReadFile(path)
+	check(t, err)
+
+	if string(data) != compositeInput {
+		t.Fatalf("expected the file on disk to be untouched, got:\n%s", string(data))
+	}
+}
@@ -0,0 +1,215 @@
+package tracegen
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A Diff describes the lines changed in each file, keyed by absolute filename.
+// A file mapped to no ranges was added in its entirety.
+type Diff map[string][]LineRange
+
+// A LineRange is an inclusive range of 1-based line numbers.
+type LineRange struct {
+	Start, End int
+}
+
+// Contains reports whether filename was changed.
+func (d Diff) Contains(filename string) bool {
+	_, ok := d[filename]
+	return ok
+}
+
+// Overlaps reports whether any of the lines from start to end, inclusive, were
+// changed in filename.
+func (d Diff) Overlaps(filename string, start, end int) bool {
+	ranges, ok := d[filename]
+	if !ok {
+		return false
+	}
+
+	if ranges == nil {
+		return true
+	}
+
+	for _, r := range ranges {
+		if r.Start <= end && start <= r.End {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GitDiff returns the Go files changed in the git repository containing dir.
+// If staged is set, only staged changes are included, and otherwise those in
+// the working tree, along with untracked files. Staged changes are located by
+// their lines in the index, so an error is returned if any of the files with
+// staged changes have unstaged changes too. If since is non-empty, changes
+// are relative to the merge base of since and HEAD, e.g. to include every
+// change on a branch, and otherwise to HEAD. It shells out to git.
+func GitDiff(dir, since string, staged bool) (Diff, error) {
+	// The root is found relative to dir, rather than using --show-toplevel,
+	// so that filenames match those loaded from dir when it's reached via a
+	// symlink
+	cdup, err := git(dir, "rev-parse", "--show-cdup")
+	if err != nil {
+		return nil, err
+	}
+
+	top := filepath.Join(absPath(dir), strings.TrimSpace(string(cdup)))
+
+	args := []string{"diff", "--no-color", "--no-ext-diff", "--unified=0", "--src-prefix=a/", "--dst-prefix=b/"}
+	if staged {
+		args = append(args, "--cached")
+	}
+
+	if since != "" {
+		base, err := git(dir, "merge-base", since, "HEAD")
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, strings.TrimSpace(string(base)))
+	} else if !staged {
+		// Otherwise the working tree would be compared with the index
+		args = append(args, "HEAD")
+	}
+
+	out, err := git(dir, append(args, "--", "*.go")...)
+	if err != nil {
+		return nil, err
+	}
+
+	diff, err := parseDiff(top, out)
+	if err != nil {
+		return nil, err
+	}
+
+	if staged {
+		if err := checkUnstaged(dir, top, diff); err != nil {
+			return nil, err
+		}
+	} else {
+		untracked, err := git(dir, "ls-files", "--others", "--exclude-standard", "--full-name", "--", "*.go")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range strings.Split(strings.TrimSpace(string(untracked)), "\n") {
+			if name != "" {
+				diff[filepath.Join(top, filepath.FromSlash(name))] = nil
+			}
+		}
+	}
+
+	return diff, nil
+}
+
+// checkUnstaged returns an error if any of the files in diff, whose names are
+// relative to root, differ between the index and the working tree.
+func checkUnstaged(dir, root string, diff Diff) error {
+	out, err := git(dir, "diff", "--name-only", "--no-ext-diff", "--", "*.go")
+	if err != nil {
+		return err
+	}
+
+	for _, name := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if name == "" {
+			continue
+		}
+
+		if filename := filepath.Join(root, filepath.FromSlash(name)); diff.Contains(filename) {
+			return errors.Errorf("%s has both staged and unstaged changes; stage or stash the rest to use staged changes", filename)
+		}
+	}
+
+	return nil
+}
+
+// git runs git in dir with the given arguments, returning its output.
+func git(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-c", "core.quotePath=false"}, args...)...)
+	cmd.Dir = dir
+
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
+
+var hunkPattern = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@`)
+
+// parseDiff parses the output of git diff with no context lines, where
+// filenames are relative to root.
+func parseDiff(root string, out []byte) (Diff, error) {
+	diff := make(Diff)
+
+	// The file the following hunks apply to, if it still exists
+	var filename string
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(nil, 1<<24)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.HasPrefix(line, "+++ ") {
+			name := strings.TrimPrefix(line, "+++ ")
+			if unquoted, err := strconv.Unquote(name); err == nil {
+				name = unquoted
+			}
+
+			filename = ""
+			if strings.HasPrefix(name, "b/") {
+				filename = filepath.Join(root, filepath.FromSlash(strings.TrimPrefix(name, "b/")))
+				diff[filename] = []LineRange{}
+			}
+
+			continue
+		}
+
+		m := hunkPattern.FindStringSubmatch(line)
+		if m == nil || filename == "" {
+			continue
+		}
+
+		start, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid hunk %q", line)
+		}
+
+		count := 1
+		if m[2] != "" {
+			if count, err = strconv.Atoi(m[2]); err != nil {
+				return nil, errors.Wrapf(err, "invalid hunk %q", line)
+			}
+		}
+
+		r := LineRange{Start: start, End: start + count - 1}
+		if count == 0 {
+			// Lines were only removed, between start and the line after it
+			r = LineRange{Start: start, End: start + 1}
+		}
+
+		diff[filename] = append(diff[filename], r)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read diff")
+	}
+
+	return diff, nil
+}
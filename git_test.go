@@ -0,0 +1,171 @@
+package tracegen
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver"
+)
+
+const gitInput = `package main
+
+func A() {
+	println("a")
+}
+
+func B() {
+	println("b")
+}
+
+// C is documented.
+func C() {
+	println("c")
+}
+`
+
+// gitRepo writes gitInput to a module within a new git repository, and commits
+// it on the main branch.
+func gitRepo(t *testing.T) (dir string) {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	path := writeModule(t, gitInput)
+	dir = filepath.Dir(path)
+
+	runGit(t, dir, "init", "-q", "-b", "main")
+	runGit(t, dir, "add", ".")
+	runGit(t, dir, "commit", "-q", "-m", "initial")
+
+	return dir
+}
+
+func runGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %s failed: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+// touchedFuncs returns the functions in gitInput touched by diff.
+func touchedFuncs(diff Diff, path string) (funcs []string) {
+	ranges := map[string][2]int{"A": {3, 5}, "B": {7, 9}, "C": {11, 14}}
+
+	for _, name := range []string{"A", "B", "C"} {
+		if diff.Overlaps(path, ranges[name][0], ranges[name][1]) {
+			funcs = append(funcs, name)
+		}
+	}
+
+	return funcs
+}
+
+func TestGitDiff(t *testing.T) {
+	dir := gitRepo(t)
+	path := filepath.Join(dir, "sample.go")
+
+	// B is staged, the doc comment of C is only changed in the working tree,
+	// and new.go is untracked
+	check(t, os.WriteFile(path, []byte(strings.Replace(gitInput, `"b"`, `"B"`, 1)), 0644))
+	runGit(t, dir, "add", "sample.go")
+
+	check(t, os.WriteFile(path, []byte(strings.Replace(strings.Replace(gitInput, `"b"`, `"B"`, 1), "is documented", "has docs", 1)), 0644))
+	check(t, os.WriteFile(filepath.Join(dir, "new.go"), []byte("package main\n"), 0644))
+
+	diff, err := GitDiff(dir, "", false)
+	check(t, err)
+
+	if funcs := touchedFuncs(diff, path); !reflect.DeepEqual(funcs, []string{"B", "C"}) {
+		t.Fatalf("expected B and C to be touched, got %v", funcs)
+	}
+
+	if !diff.Overlaps(filepath.Join(dir, "new.go"), 1, 1) {
+		t.Fatal("expected the untracked file to be included")
+	}
+
+	// The staged lines of a partly staged file needn't match the working tree
+	if _, err := GitDiff(dir, "", true); err == nil || !strings.Contains(err.Error(), "unstaged changes") {
+		t.Fatalf("expected an error for a partly staged file, got %v", err)
+	}
+
+	runGit(t, dir, "add", "sample.go")
+
+	staged, err := GitDiff(dir, "", true)
+	check(t, err)
+
+	if funcs := touchedFuncs(staged, path); !reflect.DeepEqual(funcs, []string{"B", "C"}) {
+		t.Fatalf("expected B and C to be staged, got %v", funcs)
+	}
+
+	if staged.Contains(filepath.Join(dir, "new.go")) {
+		t.Fatal("expected the untracked file to be excluded from staged changes")
+	}
+}
+
+func TestGitDiffSince(t *testing.T) {
+	dir := gitRepo(t)
+	path := filepath.Join(dir, "sample.go")
+
+	runGit(t, dir, "checkout", "-q", "-b", "feature")
+
+	check(t, os.WriteFile(path, []byte(strings.Replace(gitInput, `"a"`, `"A"`, 1)), 0644))
+	runGit(t, dir, "commit", "-q", "-am", "change A")
+
+	// The working tree's changes are included too
+	check(t, os.WriteFile(path, []byte(strings.Replace(strings.Replace(gitInput, `"a"`, `"A"`, 1), `"c"`, `"C"`, 1)), 0644))
+
+	diff, err := GitDiff(dir, "main", false)
+	check(t, err)
+
+	if funcs := touchedFuncs(diff, path); !reflect.DeepEqual(funcs, []string{"A", "C"}) {
+		t.Fatalf("expected A and C to be touched, got %v", funcs)
+	}
+}
+
+func TestProcessorDiff(t *testing.T) {
+	dir := gitRepo(t)
+	path := filepath.Join(dir, "sample.go")
+
+	check(t, os.WriteFile(path, []byte(strings.Replace(gitInput, `"b"`, `"B"`, 1)), 0644))
+
+	diff, err := GitDiff(dir, "", false)
+	check(t, err)
+
+	var calls int
+
+	p := &Processor{
+		Updater: countingUpdater{Updater: printlnUpdater{name: "traced"}, calls: &calls},
+		GetResolver: func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+			return NewSimpleResolver(pkg, file, nil)
+		},
+		Dir:  dir,
+		Diff: diff,
+	}
+
+	_, err = p.Process([]string{"."})
+	check(t, err)
+
+	if calls != 1 {
+		t.Fatalf("expected only B to be updated, got %d calls", calls)
+	}
+
+	data, err := os.ReadFile(path)
+	check(t, err)
+
+	expected := strings.Replace(gitInput, "\tprintln(\"b\")", "\tprintln(\"traced\")\n\tprintln(\"B\")", 1)
+	if string(data) != expected {
+		t.Fatalf("mismatched output:\ngot:\n%s\nexpected:\n%s", string(data), expected)
+	}
+}
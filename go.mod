@@ -1,16 +1,15 @@
 module github.com/Deiz/tracegen
 
-go 1.17
+go 1.26.0
 
 require (
 	github.com/dave/dst v0.26.2
 	github.com/pkg/errors v0.9.1
 	github.com/spf13/pflag v1.0.5
-	golang.org/x/tools v0.1.10 // indirect
+	golang.org/x/tools v0.51.0
 )
 
 require (
-	golang.org/x/mod v0.6.0-dev.0.20220106191415-9b9b3d81d5e3 // indirect
-	golang.org/x/sys v0.0.0-20211019181941-9d821ace8654 // indirect
-	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
+	golang.org/x/mod v0.41.0 // indirect
+	golang.org/x/sync v0.23.0 // indirect
 )
@@ -0,0 +1,236 @@
+package tracegen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"golang.org/x/tools/go/callgraph/cha"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// Graph is the span hierarchy implied by the call graph: an edge from one
+// traced function to another means the former's span is expected to be the
+// parent of the latter's.
+type Graph struct {
+	Nodes []*GraphNode
+	Edges []*GraphEdge
+}
+
+type GraphNode struct {
+	ID      string
+	Span    string
+	Package string
+}
+
+// A GraphEdge connects two traced functions. Via lists any eligible but
+// untraced functions the call passes through, which are gaps in the trace.
+type GraphEdge struct {
+	From, To string
+	Via      []string
+}
+
+// BuildGraph builds the expected span hierarchy of the supplied packages,
+// using a class hierarchy analysis call graph. A function is traced if it's
+// eligible and not skipped. Calls between traced functions are followed
+// through any eligible functions that are skipped, but not through ineligible
+// functions, as they don't propagate a context. The packages must have been
+// loaded with type information.
+func BuildGraph(settings Settings, pkgs []*decorator.Package, inspect Inspector) (g *Graph, err error) {
+	g = &Graph{}
+
+	// The traced and untraced eligible functions, keyed by the position of
+	// their names, which is also the position of their SSA functions.
+	traced := make(map[token.Pos]*GraphNode)
+	untraced := make(map[token.Pos]string)
+
+	var initial []*packages.Package
+
+	for _, pkg := range pkgs {
+		if _, ok := excluded(settings, pkg); ok {
+			continue
+		}
+
+		if pkg.Types == nil || pkg.TypesInfo == nil {
+			return nil, fmt.Errorf("package %s was loaded without type information", pkg.PkgPath)
+		}
+
+		initial = append(initial, pkg.Package)
+
+		inspectFuncs(settings, pkg, func(file *dst.File, node *dst.FuncDecl, shouldSkip bool) {
+			state := inspect(node)
+			if !state.Eligible {
+				return
+			}
+
+			decl, ok := pkg.Decorator.Ast.Nodes[node].(*ast.FuncDecl)
+			if !ok {
+				return
+			}
+
+			id := pkg.PkgPath + "." + funcName(node)
+
+			if shouldSkip {
+				untraced[decl.Name.Pos()] = id
+				return
+			}
+
+			span := state.SpanName
+			if span == "" {
+				span = funcName(node)
+			}
+
+			gn := &GraphNode{ID: id, Span: span, Package: pkg.PkgPath}
+			traced[decl.Name.Pos()] = gn
+			g.Nodes = append(g.Nodes, gn)
+		})
+	}
+
+	prog, _ := ssautil.Packages(initial, 0)
+	prog.Build()
+
+	cg := cha.CallGraph(prog)
+
+	edges := make(map[[2]string]*GraphEdge)
+
+	addEdge := func(from, to string, via []string) {
+		key := [2]string{from, to}
+		if e, ok := edges[key]; ok && len(e.Via) <= len(via) {
+			return
+		}
+
+		edges[key] = &GraphEdge{From: from, To: to, Via: append([]string(nil), via...)}
+	}
+
+	// walk follows calls made by fn and its closures, adding an edge from the
+	// traced function from to any traced function reached.
+	var walk func(from string, fn *ssa.Function, via []string, visited map[*ssa.Function]bool)
+	walk = func(from string, fn *ssa.Function, via []string, visited map[*ssa.Function]bool) {
+		if visited[fn] {
+			return
+		}
+		visited[fn] = true
+
+		for _, anon := range fn.AnonFuncs {
+			walk(from, anon, via, visited)
+		}
+
+		node := cg.Nodes[fn]
+		if node == nil {
+			return
+		}
+
+		for _, out := range node.Out {
+			callee := out.Callee.Func
+			if !callee.Pos().IsValid() {
+				continue
+			}
+
+			if gn, ok := traced[callee.Pos()]; ok {
+				addEdge(from, gn.ID, via)
+			} else if id, ok := untraced[callee.Pos()]; ok {
+				walk(from, callee, append(via, id), visited)
+			}
+		}
+	}
+
+	for fn := range cg.Nodes {
+		if fn == nil || fn.Parent() != nil || !fn.Pos().IsValid() {
+			continue
+		}
+
+		if gn, ok := traced[fn.Pos()]; ok {
+			walk(gn.ID, fn, nil, make(map[*ssa.Function]bool))
+		}
+	}
+
+	for _, e := range edges {
+		g.Edges = append(g.Edges, e)
+	}
+
+	sort.Slice(g.Nodes, func(i, j int) bool {
+		return g.Nodes[i].ID < g.Nodes[j].ID
+	})
+
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+
+	return g, nil
+}
+
+// WriteDOT writes the graph in Graphviz DOT format. Edges that pass through
+// untraced functions are dashed, and labelled with those functions.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	buf := &bytes.Buffer{}
+
+	fmt.Fprintln(buf, "digraph tracegen {")
+	fmt.Fprintln(buf, "\trankdir=LR;")
+	fmt.Fprintln(buf, "\tnode [shape=box];")
+
+	for _, n := range g.Nodes {
+		fmt.Fprintf(buf, "\t%s [label=%s];\n", strconv.Quote(n.ID), strconv.Quote(n.Span+"\n"+n.Package))
+	}
+
+	for _, e := range g.Edges {
+		if len(e.Via) == 0 {
+			fmt.Fprintf(buf, "\t%s -> %s;\n", strconv.Quote(e.From), strconv.Quote(e.To))
+			continue
+		}
+
+		label := "via " + strings.Join(e.Via, ", ")
+		fmt.Fprintf(buf, "\t%s -> %s [style=dashed, label=%s];\n", strconv.Quote(e.From), strconv.Quote(e.To), strconv.Quote(label))
+	}
+
+	fmt.Fprintln(buf, "}")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteMermaid writes the graph as a Mermaid flowchart. Edges that pass
+// through untraced functions are dotted, and labelled with those functions.
+func (g *Graph) WriteMermaid(w io.Writer) error {
+	buf := &bytes.Buffer{}
+
+	ids := make(map[string]string, len(g.Nodes))
+
+	fmt.Fprintln(buf, "graph LR")
+
+	for i, n := range g.Nodes {
+		ids[n.ID] = fmt.Sprintf("n%d", i)
+		fmt.Fprintf(buf, "\t%s[\"%s<br/>%s\"]\n", ids[n.ID], mermaidEscape(n.Span), mermaidEscape(n.Package))
+	}
+
+	for _, e := range g.Edges {
+		if len(e.Via) == 0 {
+			fmt.Fprintf(buf, "\t%s --> %s\n", ids[e.From], ids[e.To])
+			continue
+		}
+
+		label := "via " + strings.Join(e.Via, ", ")
+		fmt.Fprintf(buf, "\t%s -. \"%s\" .-> %s\n", ids[e.From], mermaidEscape(label), ids[e.To])
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+var mermaidReplacer = strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;")
+
+func mermaidEscape(s string) string {
+	return mermaidReplacer.Replace(s)
+}
@@ -0,0 +1,81 @@
+package tracegen
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dave/dst"
+)
+
+const graphInput = `package main
+
+func A(x int) {
+	B(x)
+	S(x)
+	N()
+}
+
+func B(x int) {}
+
+//trace:skip
+func S(x int) {
+	C(x)
+}
+
+func C(x int) {}
+
+func N() {
+	var x int
+	C(x)
+}
+`
+
+const graphDOT = `digraph tracegen {
+	rankdir=LR;
+	node [shape=box];
+	"test.A" [label="A\ntest"];
+	"test.B" [label="B\ntest"];
+	"test.C" [label="C\ntest"];
+	"test.A" -> "test.B";
+	"test.A" -> "test.C" [style=dashed, label="via test.S"];
+}
+`
+
+const graphMermaid = `graph LR
+	n0["A<br/>test"]
+	n1["B<br/>test"]
+	n2["C<br/>test"]
+	n0 --> n1
+	n0 -. "via test.S" .-> n2
+`
+
+func TestBuildGraph(t *testing.T) {
+	path := writeModule(t, graphInput)
+	err := os.Chdir(filepath.Dir(path))
+	check(t, err)
+
+	pkgs, err := (&Processor{Types: true}).Load([]string{"."})
+	check(t, err)
+
+	g, err := BuildGraph(Settings{}, pkgs, func(fn *dst.FuncDecl) (state FuncState) {
+		state.Eligible = len(fn.Type.Params.List) > 0
+		return state
+	})
+	check(t, err)
+
+	buf := &bytes.Buffer{}
+	check(t, g.WriteDOT(buf))
+
+	if buf.String() != graphDOT {
+		t.Fatalf("mismatched dot output:\ngot:\n%s\nexpected:\n%s", buf.String(), graphDOT)
+	}
+
+	buf.Reset()
+	check(t, g.WriteMermaid(buf))
+
+	if buf.String() != graphMermaid {
+		t.Fatalf("mismatched mermaid output:\ngot:\n%s\nexpected:\n%s", buf.String(), graphMermaid)
+	}
+}
@@ -0,0 +1,118 @@
+package tracegen
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// FileHook may be implemented by an Updater that needs to modify files outside
+// of function bodies, e.g. to add package-level declarations. BeforeFile is
+// invoked before any of the file's functions are updated, and AfterFile once
+// they all have been. Any returned imports are added to the file.
+type FileHook interface {
+	BeforeFile(fc *FileContext) (imports []string, err error)
+	AfterFile(fc *FileContext) (imports []string, err error)
+}
+
+// PackageHook may be implemented by an Updater that needs to manage whole
+// files, e.g. a generated file per package. BeforePackage is invoked before
+// any of the package's files are processed, and AfterPackage once they all
+// have been.
+type PackageHook interface {
+	BeforePackage(pc *PackageContext) error
+	AfterPackage(pc *PackageContext) error
+}
+
+// TypesUser may be implemented by an Updater that reads FuncContext.TypesInfo,
+// or otherwise needs packages to be type-checked. Packages are loaded with
+// their syntax alone unless NeedsTypes reports true, as type-checking is
+// considerably slower.
+type TypesUser interface {
+	NeedsTypes() bool
+}
+
+// needsTypes reports whether update needs packages to be type-checked.
+func needsTypes(update Updater) bool {
+	u, ok := update.(TypesUser)
+	return ok && u.NeedsTypes()
+}
+
+// FileContext describes a file being visited by a FileHook.
+type FileContext struct {
+	File     *dst.File
+	Filename string
+
+	Package *decorator.Package
+	PkgPath string
+}
+
+// PackageContext describes a package being visited by a PackageHook, and
+// collects any files the hook creates, replaces or removes. Files are written
+// or removed along with the rest of the run's changes, and only if their
+// contents differ from those on disk.
+type PackageContext struct {
+	Package *decorator.Package
+	PkgPath string
+	Dir     string
+
+	// Files set or removed by the hook, keyed by path. Removed files are nil.
+	files map[string]*dst.File
+}
+
+func newPackageContext(pkg *decorator.Package) *PackageContext {
+	return &PackageContext{
+		Package: pkg,
+		PkgPath: pkg.PkgPath,
+		Dir:     pkg.Dir,
+		files:   make(map[string]*dst.File),
+	}
+}
+
+// SetFile creates or replaces the named file, which is relative to the
+// package's directory unless absolute. Imports are resolved and added in the
+// same way as for existing files.
+func (pc *PackageContext) SetFile(name string, file *dst.File) {
+	pc.files[pc.path(name)] = file
+}
+
+// RemoveFile removes the named file, which is relative to the package's
+// directory unless absolute, if it exists.
+func (pc *PackageContext) RemoveFile(name string) {
+	pc.files[pc.path(name)] = nil
+}
+
+// File returns the named file from the package's syntax, or nil if it's not
+// part of the package.
+func (pc *PackageContext) File(name string) *dst.File {
+	path := pc.path(name)
+
+	for _, file := range pc.Package.Syntax {
+		if pc.Package.Decorator.Filenames[file] == path {
+			return file
+		}
+	}
+
+	return nil
+}
+
+func (pc *PackageContext) path(name string) string {
+	if filepath.IsAbs(name) {
+		return filepath.Clean(name)
+	}
+
+	return filepath.Join(pc.Dir, name)
+}
+
+// filenames returns the paths of the files set or removed, in sorted order.
+func (pc *PackageContext) filenames() (names []string) {
+	for name := range pc.files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
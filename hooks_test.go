@@ -0,0 +1,212 @@
+package tracegen
+
+import (
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver"
+)
+
+const hooksInput = `package main
+
+func Foo() {}
+`
+
+const hooksOutput = `package main
+
+var tracer = "test"
+
+func Foo() {}
+`
+
+const hooksGenerated = `package main
+
+const generated = true
+`
+
+// hookUpdater declares a tracer variable in every file, writes a generated
+// file per package and removes a stale one.
+type hookUpdater struct{}
+
+func (hookUpdater) Update(fc *FuncContext) (imports []string, err error) {
+	return nil, nil
+}
+
+func (hookUpdater) BeforeFile(fc *FileContext) (imports []string, err error) {
+	return nil, nil
+}
+
+func (hookUpdater) AfterFile(fc *FileContext) (imports []string, err error) {
+	for _, decl := range fc.File.Decls {
+		if gd, ok := decl.(*dst.GenDecl); ok && gd.Tok == token.VAR {
+			return nil, nil
+		}
+	}
+
+	decl := &dst.GenDecl{
+		Tok: token.VAR,
+		Specs: []dst.Spec{&dst.ValueSpec{
+			Names:  []*dst.Ident{dst.NewIdent("tracer")},
+			Values: []dst.Expr{&dst.BasicLit{Kind: token.STRING, Value: strconv.Quote(fc.PkgPath)}},
+		}},
+		Decs: dst.GenDeclDecorations{NodeDecs: dst.NodeDecs{Before: dst.EmptyLine, After: dst.EmptyLine}},
+	}
+
+	fc.File.Decls = append([]dst.Decl{decl}, fc.File.Decls...)
+
+	return nil, nil
+}
+
+func (hookUpdater) BeforePackage(pc *PackageContext) error {
+	pc.RemoveFile("stale.go")
+	return nil
+}
+
+func (hookUpdater) AfterPackage(pc *PackageContext) error {
+	pc.SetFile("zz_generated.go", &dst.File{
+		Name: dst.NewIdent(pc.Package.Name),
+		Decls: []dst.Decl{&dst.GenDecl{
+			Tok: token.CONST,
+			Specs: []dst.Spec{&dst.ValueSpec{
+				Names:  []*dst.Ident{dst.NewIdent("generated")},
+				Values: []dst.Expr{dst.NewIdent("true")},
+			}},
+			Decs: dst.GenDeclDecorations{NodeDecs: dst.NodeDecs{Before: dst.EmptyLine}},
+		}},
+	})
+
+	return nil
+}
+
+func TestProcessHooks(t *testing.T) {
+	path := writeModule(t, hooksInput)
+	dir := filepath.Dir(path)
+
+	stale := filepath.Join(dir, "stale.go")
+	err := os.WriteFile(stale, []byte("package main\n"), 0644)
+	check(t, err)
+
+	err = os.Chdir(dir)
+	check(t, err)
+
+	getResolver := func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+		return NewSimpleResolver(pkg, file, nil)
+	}
+
+	result, err := Process(Settings{}, []string{"."}, Composite{hookUpdater{}}, getResolver)
+	check(t, err)
+
+	pkg := result.Packages[0]
+	if len(pkg.Changed) != 2 || len(pkg.Deleted) != 1 || pkg.Deleted[0] != stale {
+		t.Fatalf("unexpected changes, got changed %v and deleted %v", pkg.Changed, pkg.Deleted)
+	}
+
+	for filename, expected := range map[string]string{path: hooksOutput, filepath.Join(dir, "zz_generated.go"): hooksGenerated} {
+		data, err := os.ReadFile(filename)
+		check(t, err)
+
+		if string(data) != expected {
+			t.Fatalf("mismatched output in %s:\ngot:\n%s\nexpected:\n%s", filename, string(data), expected)
+		}
+	}
+
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed, got %v", stale, err)
+	}
+
+	// A second run, which includes the generated file, should change nothing.
+	result, err = Process(Settings{}, []string{"."}, hookUpdater{}, getResolver)
+	check(t, err)
+
+	if pkg := result.Packages[0]; len(pkg.Changed) != 0 || len(pkg.Deleted) != 0 {
+		t.Fatalf("expected no changes on second run, got changed %v and deleted %v", pkg.Changed, pkg.Deleted)
+	}
+}
+
+// operatorHook negates the first binary expression in each file from its
+// AfterFile hook, changing nothing but the operator.
+type operatorHook struct{}
+
+func (operatorHook) Update(fc *FuncContext) (imports []string, err error) {
+	return nil, nil
+}
+
+func (operatorHook) BeforeFile(fc *FileContext) (imports []string, err error) {
+	return nil, nil
+}
+
+func (operatorHook) AfterFile(fc *FileContext) (imports []string, err error) {
+	dst.Inspect(fc.File, func(n dst.Node) bool {
+		if expr, ok := n.(*dst.BinaryExpr); ok {
+			expr.Op = token.SUB
+			return false
+		}
+
+		return true
+	})
+
+	return nil, nil
+}
+
+func TestFileHookChangesWithinFunctions(t *testing.T) {
+	path := writeModule(t, "package main\n\nfunc Foo() int {\n\treturn 1 + 2\n}\n")
+
+	p := &Processor{
+		Updater: operatorHook{},
+		GetResolver: func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+			return NewSimpleResolver(pkg, file, nil)
+		},
+		Dir: filepath.Dir(path),
+	}
+
+	_, err := p.Process([]string{"."})
+	check(t, err)
+
+	data, err := os.ReadFile(path)
+	check(t, err)
+
+	if expected := "package main\n\nfunc Foo() int {\n\treturn 1 - 2\n}\n"; string(data) != expected {
+		t.Fatalf("mismatched output:\ngot:\n%s\nexpected:\n%s", string(data), expected)
+	}
+}
+
+func TestProcessHooksMemFS(t *testing.T) {
+	path := writeModule(t, hooksInput)
+	dir := filepath.Dir(path)
+
+	stale := filepath.Join(dir, "stale.go")
+	err := os.WriteFile(stale, []byte("package main\n"), 0644)
+	check(t, err)
+
+	mem := NewMemFS(nil)
+
+	p := &Processor{
+		Updater: hookUpdater{},
+		GetResolver: func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+			return NewSimpleResolver(pkg, file, nil)
+		},
+		FS:  mem,
+		Dir: dir,
+	}
+
+	// The stale file is only on disk, but can still be removed from memory
+	result, err := p.Process([]string{"."})
+	check(t, err)
+
+	if pkg := result.Packages[0]; len(pkg.Deleted) != 1 || pkg.Deleted[0] != stale {
+		t.Fatalf("expected %s to be deleted, got %v", stale, pkg.Deleted)
+	}
+
+	if _, err := mem.ReadFile(stale); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed from memory, got %v", stale, err)
+	}
+
+	if _, err := os.Stat(stale); err != nil {
+		t.Fatalf("expected %s to be left on disk, got %v", stale, err)
+	}
+}
@@ -10,10 +10,18 @@ import (
 	"github.com/dave/dst/decorator"
 )
 
-func addImport(pkg *decorator.Package, file *dst.File, imp string) {
+// addImport adds imp to file's imports, reporting whether the file was
+// modified.
+func addImport(pkg *decorator.Package, file *dst.File, imp string) bool {
 	for _, pkg := range pkg.Imports {
 		if pkg.Name == imp {
-			return
+			return false
+		}
+	}
+
+	for _, spec := range file.Imports {
+		if mustUnquote(spec.Path.Value) == imp {
+			return false
 		}
 	}
 
@@ -51,11 +59,11 @@ func addImport(pkg *decorator.Package, file *dst.File, imp string) {
 			spec.Decorations().Before = dst.NewLine
 
 			n.Specs = append(n.Specs[:j], append([]dst.Spec{importSpec}, n.Specs[j:]...)...)
-			return
+			return true
 		}
 
 		n.Specs = append(n.Specs, importSpec)
-		return
+		return true
 	}
 
 	gd := &dst.GenDecl{
@@ -67,6 +75,8 @@ func addImport(pkg *decorator.Package, file *dst.File, imp string) {
 	}
 
 	file.Decls = append(file.Decls[:index], append([]dst.Decl{gd}, file.Decls[index:]...)...)
+
+	return true
 }
 
 func mustUnquote(s string) string {
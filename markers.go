@@ -0,0 +1,277 @@
+package tracegen
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/dave/dst"
+)
+
+// Comments delimiting regions managed by Markers. The begin marker carries a
+// hash of the region's contents, so that hand edits can be detected.
+const (
+	BeginMarker = "// tracegen:begin"
+	EndMarker   = "// tracegen:end"
+)
+
+var beginPattern = regexp.MustCompile(`^//\s*tracegen:begin(?:\s+(\w+))?\s*$`)
+
+// Markers wraps an updater so that the statements it inserts are delimited by
+// BeginMarker and EndMarker comments, e.g.
+//
+//	func Foo(ctx context.Context) {
+//		// tracegen:begin 1a2b3c4d
+//		span, ctx := opentracing.StartSpanFromContext(ctx, "Foo")
+//		defer span.Finish()
+//		// tracegen:end
+//
+//		// ...
+//	}
+//
+// Any existing region is removed before the updater is invoked, so the
+// updater only ever sees a function without its statements, and doesn't need
+// to recognise them itself. Statements added by the updater (those that
+// weren't in the body beforehand) are then wrapped in a new region.
+//
+// A region whose contents no longer match its hash has been edited by hand.
+// Such functions are left untouched, and reported via FuncContext.Warnf.
+//
+// Hooks are forwarded to the wrapped updater if it implements them.
+type Markers struct {
+	Updater Updater
+}
+
+func (m Markers) Update(fc *FuncContext) (imports []string, err error) {
+	body := fc.Func.Body
+	if body == nil {
+		return m.Updater.Update(fc)
+	}
+
+	r, ok := findRegion(body)
+	if ok && r.edited(body) {
+		fc.Warnf("managed region of %s was edited by hand; leaving it untouched", funcName(fc.Func))
+		return nil, nil
+	}
+
+	// Comments preceding the begin marker, which are kept above the region
+	var leading []string
+	var next dst.Stmt
+
+	if ok {
+		leading = r.remove(body)
+
+		if r.start < len(body.List) {
+			next = body.List[r.start]
+		}
+	}
+
+	existing := make(map[dst.Stmt]bool, len(body.List))
+	for _, stmt := range body.List {
+		existing[stmt] = true
+	}
+
+	imports, err = m.Updater.Update(fc)
+	if err != nil {
+		return nil, err
+	}
+
+	first, last := -1, -1
+	for i, stmt := range body.List {
+		if existing[stmt] {
+			continue
+		}
+
+		if first >= 0 && last != i-1 {
+			return nil, fmt.Errorf("updater inserted non-contiguous statements into %s", funcName(fc.Func))
+		}
+
+		if first < 0 {
+			first = i
+		}
+
+		last = i
+	}
+
+	if first >= 0 {
+		wrapRegion(body.List[first : last+1])
+		next = body.List[first]
+	}
+
+	if len(leading) > 0 {
+		if next != nil {
+			decs := next.Decorations()
+			decs.Start = append(leading, decs.Start...)
+		} else if n := len(body.List); n > 0 {
+			decs := body.List[n-1].Decorations()
+			decs.End = append(append(decs.End, "\n"), leading...)
+		} else {
+			body.Decs.Lbrace = append(body.Decs.Lbrace, leading...)
+		}
+	}
+
+	return imports, nil
+}
+
+// BeforeFile invokes the wrapped updater's BeforeFile hook, if any.
+func (m Markers) BeforeFile(fc *FileContext) (imports []string, err error) {
+	if h, ok := m.Updater.(FileHook); ok {
+		return h.BeforeFile(fc)
+	}
+
+	return nil, nil
+}
+
+// AfterFile invokes the wrapped updater's AfterFile hook, if any.
+func (m Markers) AfterFile(fc *FileContext) (imports []string, err error) {
+	if h, ok := m.Updater.(FileHook); ok {
+		return h.AfterFile(fc)
+	}
+
+	return nil, nil
+}
+
+// BeforePackage invokes the wrapped updater's BeforePackage hook, if any.
+func (m Markers) BeforePackage(pc *PackageContext) error {
+	if h, ok := m.Updater.(PackageHook); ok {
+		return h.BeforePackage(pc)
+	}
+
+	return nil
+}
+
+// AfterPackage invokes the wrapped updater's AfterPackage hook, if any.
+func (m Markers) AfterPackage(pc *PackageContext) error {
+	if h, ok := m.Updater.(PackageHook); ok {
+		return h.AfterPackage(pc)
+	}
+
+	return nil
+}
+
+// NeedsTypes reports whether the wrapped updater needs packages to be
+// type-checked.
+func (m Markers) NeedsTypes() bool {
+	return needsTypes(m.Updater)
+}
+
+// region is a span of statements delimited by markers.
+type region struct {
+	start, end int
+	hash       string
+
+	// Whether the end marker is at the start of the statement following the
+	// region, rather than at the end of the region's last statement.
+	endInNext bool
+}
+
+// findRegion returns the first managed region in body.
+func findRegion(body *dst.BlockStmt) (r region, ok bool) {
+	r.start = -1
+
+	for i, stmt := range body.List {
+		decs := stmt.Decorations()
+
+		if r.start < 0 {
+			for _, c := range decs.Start {
+				if m := beginPattern.FindStringSubmatch(c); m != nil {
+					r.start, r.hash = i, m[1]
+					break
+				}
+			}
+
+			if r.start < 0 {
+				continue
+			}
+		} else if indexMarker(decs.Start, EndMarker) >= 0 {
+			r.end, r.endInNext = i-1, true
+			return r, true
+		}
+
+		if indexMarker(decs.End, EndMarker) >= 0 {
+			r.end = i
+			return r, true
+		}
+	}
+
+	return r, false
+}
+
+// edited reports whether the region's contents in body don't match its hash.
+func (r region) edited(body *dst.BlockStmt) bool {
+	return r.hash != regionHash(body.List[r.start:r.end+1])
+}
+
+// remove removes the region's statements and markers from body, returning
+// any comments that preceded the begin marker.
+func (r region) remove(body *dst.BlockStmt) (leading []string) {
+	for _, c := range body.List[r.start].Decorations().Start {
+		if beginPattern.MatchString(c) {
+			break
+		}
+
+		leading = append(leading, c)
+	}
+
+	rest := append(append([]dst.Stmt(nil), body.List[:r.start]...), body.List[r.end+1:]...)
+
+	if r.endInNext {
+		next := body.List[r.end+1].Decorations()
+		next.Start = removeMarker(next.Start, EndMarker)
+	}
+
+	if r.start < len(rest) {
+		rest[r.start].Decorations().Before = dst.NewLine
+	}
+
+	if r.start > 0 && r.start == len(rest) {
+		rest[r.start-1].Decorations().After = dst.NewLine
+	}
+
+	body.List = rest
+
+	return leading
+}
+
+// regionHash returns a short hash of the structure of stmts, ignoring
+// decorations.
+func regionHash(stmts []dst.Stmt) string {
+	h := sha256.New()
+
+	for _, stmt := range stmts {
+		for _, line := range dumpLines(stmt) {
+			fmt.Fprintln(h, line)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:8]
+}
+
+// wrapRegion adds markers around stmts.
+func wrapRegion(stmts []dst.Stmt) {
+	first, last := stmts[0].Decorations(), stmts[len(stmts)-1].Decorations()
+
+	first.Start = append(first.Start, BeginMarker+" "+regionHash(stmts))
+	last.End = append(last.End, "\n", EndMarker)
+}
+
+func indexMarker(decs []string, marker string) int {
+	for i, c := range decs {
+		if strings.TrimSpace(c) == marker {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func removeMarker(decs []string, marker string) []string {
+	i := indexMarker(decs, marker)
+	if i < 0 {
+		return decs
+	}
+
+	return append(append([]string(nil), decs[:i]...), decs[i+1:]...)
+}
@@ -0,0 +1,162 @@
+package tracegen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver"
+)
+
+const markersTemplate = `package template
+
+func _(__ctx__ int) {
+	println(__ctx__, "__span__")
+}
+`
+
+const markersInput = `package main
+
+func Foo(ctx int) {
+	// Leading comment
+	println("body")
+}
+
+//trace:skip
+func Bar(ctx int) {
+	println("body")
+}
+`
+
+// The %s placeholder is replaced with the region's hash.
+const markersOutput = `package main
+
+func Foo(ctx int) {
+	// tracegen:begin %s
+	println(ctx, "Foo")
+	// tracegen:end
+
+	// Leading comment
+	println("body")
+}
+
+//trace:skip
+func Bar(ctx int) {
+	println("body")
+}
+`
+
+func TestMarkers(t *testing.T) {
+	template, err := NewTemplateUpdater([]byte(markersTemplate))
+	check(t, err)
+
+	update := Markers{Updater: template}
+
+	getResolver := func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+		return NewSimpleResolver(pkg, file, nil)
+	}
+
+	path := writeModule(t, markersInput)
+	err = os.Chdir(filepath.Dir(path))
+	check(t, err)
+
+	for i := 0; i < 2; i++ {
+		result, err := Process(Settings{}, []string{"."}, update, getResolver)
+		check(t, err)
+
+		if len(result.Warnings) > 0 {
+			t.Fatalf("unexpected warnings on run %d: %v", i+1, result.Warnings)
+		}
+
+		data, err := os.ReadFile(path)
+		check(t, err)
+
+		file, err := decorator.Parse(data)
+		check(t, err)
+
+		body := file.Decls[0].(*dst.FuncDecl).Body
+		expected := strings.Replace(markersOutput, "%s", regionHash(body.List[:1]), 1)
+
+		if string(data) != expected {
+			t.Fatalf("mismatched output on run %d:\ngot:\n%s\nexpected:\n%s", i+1, string(data), expected)
+		}
+	}
+
+	// Skipping the function removes the region
+	data, err := os.ReadFile(path)
+	check(t, err)
+
+	err = os.WriteFile(path, []byte(strings.Replace(string(data), "func Foo", "//trace:skip\nfunc Foo", 1)), 0644)
+	check(t, err)
+
+	_, err = Process(Settings{}, []string{"."}, update, getResolver)
+	check(t, err)
+
+	data, err = os.ReadFile(path)
+	check(t, err)
+
+	if strings.Contains(string(data), "tracegen:") || strings.Contains(string(data), `"Foo"`) {
+		t.Fatalf("expected the region to be removed, got:\n%s", string(data))
+	}
+
+	if !strings.Contains(string(data), "\t// Leading comment\n\tprintln(\"body\")") {
+		t.Fatalf("expected the leading comment to be kept, got:\n%s", string(data))
+	}
+}
+
+func TestMarkersEdited(t *testing.T) {
+	template, err := NewTemplateUpdater([]byte(markersTemplate))
+	check(t, err)
+
+	update := Markers{Updater: template}
+
+	getResolver := func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+		return NewSimpleResolver(pkg, file, nil)
+	}
+
+	path := writeModule(t, markersInput)
+	err = os.Chdir(filepath.Dir(path))
+	check(t, err)
+
+	_, err = Process(Settings{}, []string{"."}, update, getResolver)
+	check(t, err)
+
+	data, err := os.ReadFile(path)
+	check(t, err)
+
+	edited := strings.Replace(string(data), `println(ctx, "Foo")`, `println(ctx, "Foo", "edited")`, 1)
+	err = os.WriteFile(path, []byte(edited), 0644)
+	check(t, err)
+
+	result, err := Process(Settings{}, []string{"."}, update, getResolver)
+	check(t, err)
+
+	if len(result.Warnings) != 1 || result.Warnings[0].Rule != RuleUpdater {
+		t.Fatalf("expected a warning about the edited region, got %v", result.Warnings)
+	}
+
+	data, err = os.ReadFile(path)
+	check(t, err)
+
+	if string(data) != edited {
+		t.Fatalf("expected the edited region to be left untouched, got:\n%s", string(data))
+	}
+
+	pkgs, err := LoadPackages([]string{"."})
+	check(t, err)
+
+	findings, err := Check(Settings{}, pkgs, template.Inspect)
+	check(t, err)
+
+	var found bool
+	for _, f := range findings {
+		found = found || f.Rule == RuleEditedRegion
+	}
+
+	if !found {
+		t.Fatalf("expected an %s finding, got %v", RuleEditedRegion, findings)
+	}
+}
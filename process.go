@@ -2,184 +2,905 @@ package tracegen
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
+	"go/ast"
 	"go/token"
+	"hash/fnv"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/dave/dst"
 	"github.com/dave/dst/decorator"
 	"github.com/dave/dst/decorator/resolver"
+	"github.com/dave/dst/decorator/resolver/goast"
+	"github.com/dave/dst/decorator/resolver/gotypes"
+	"github.com/dave/dst/decorator/resolver/guess"
 	"github.com/pkg/errors"
+	gopackages "golang.org/x/tools/go/packages"
 )
 
-var (
-	writer func(name string, data []byte, perm os.FileMode) error = os.WriteFile
-)
+// A Processor applies an updater to the packages it loads. Unlike the
+// package-level functions, which use the operating system's filesystem, a
+// Processor can read and write through any FS, e.g. a MemFS.
+//
+// A Processor may be used concurrently, provided its updater is safe for
+// concurrent use.
+//
+// If Jobs is greater than one, packages are processed concurrently, and the
+// updater (along with any hooks) may be called concurrently for functions in
+// different packages. Calls for functions within a single package are always
+// made sequentially, in order, from a single goroutine. Results and writes are
+// the same regardless of Jobs.
+type Processor struct {
+	Settings Settings
+	Updater  Updater
+
+	// GetResolver must be capable of matching any pre-existing import within
+	// the loaded packages as well as any introduced by the updater.
+	GetResolver func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver
+
+	// FS defaults to OSFS.
+	FS FS
+
+	// Dir is the directory package patterns are resolved in, defaulting to
+	// the current directory.
+	Dir string
+
+	// Jobs is the maximum number of packages to process concurrently. Values
+	// below one are treated as one.
+	Jobs int
+
+	// Cache, if non-nil, is used by Process to skip packages that converged
+	// in an earlier run, and to record those that converge in this one.
+	Cache *Cache
+
+	// Types causes packages to be type-checked even if the updater doesn't
+	// implement TypesUser, e.g. for a resolver that uses type information.
+	Types bool
+
+	// Verify causes each rewritten package to be type-checked in memory
+	// before anything is written. If a package fails, a VerifyError is
+	// returned, and no files are written.
+	Verify bool
+
+	// Diff, if non-nil, limits the updater to the functions whose lines,
+	// including their doc comments, it touches. Process also skips packages
+	// with no changed files.
+	Diff Diff
+}
+
+func (p *Processor) jobs() int {
+	if p.Jobs < 1 {
+		return 1
+	}
+
+	return p.Jobs
+}
+
+func (p *Processor) fs() FS {
+	if p.FS == nil {
+		return OSFS{}
+	}
+
+	return p.FS
+}
 
-// Process applies the specified update function to relevant functions discovered
+// Process applies the specified updater to relevant functions discovered
 // within packages matching the passed-in package patterns. The supplied resolver
 // must be capable of matching any pre-existing import within the loaded packages
 // as well as any introduced by the update function.
-func Process(settings Settings, packages []string, update func(fn *dst.FuncDecl, shouldSkip bool) (imports []string), getResolver func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver) (err error) {
-	pkgs, err := LoadPackages(packages)
+func Process(settings Settings, packages []string, update Updater, getResolver func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver) (result *Result, err error) {
+	p := &Processor{Settings: settings, Updater: update, GetResolver: getResolver}
+	return p.Process(packages)
+}
+
+// Process is like the package-level Process. The matching packages are listed
+// up front, then loaded in batches as they're about to be processed, and each
+// is only decorated when it's reached, so memory use doesn't grow with the
+// number of packages.
+func (p *Processor) Process(packages []string) (result *Result, err error) {
+	start := time.Now()
+
+	listed, err := p.list(packages)
 	if err != nil {
-		return
+		return nil, err
+	}
+
+	if p.Diff != nil {
+		listed = changedPackages(listed, p.Diff)
+	}
+
+	listedAt := time.Now()
+
+	// The cache key of each package, if it was looked up, and whether it had
+	// converged
+	keys := make([]string, len(listed))
+	cached := make([]bool, len(listed))
+
+	size := batchSize(len(listed), p.jobs())
+	batches := make([]batch, (len(listed)+size-1)/size)
+
+	result, err = p.run(len(listed), size, func(i int) (*PackageResult, []fileChange, error) {
+		pkg := listed[i]
+		dir := packageDir(pkg)
+
+		if pattern, ok := excludedDir(p.Settings, dir, pkg.Name); ok {
+			return &PackageResult{Path: pkg.PkgPath, Dir: dir, Excluded: true, ExcludedBy: pattern}, nil, nil
+		}
+
+		// Each batch is claimed by a single goroutine
+		b := &batches[i/size]
+		b.once.Do(func() {
+			start := i / size * size
+			end := start + size
+			if end > len(listed) {
+				end = len(listed)
+			}
+
+			b.err = p.loadBatch(b, listed[start:end], keys[start:end], cached[start:end])
+		})
+
+		if b.err != nil {
+			return &PackageResult{Path: pkg.PkgPath, Dir: dir}, nil, b.err
+		}
+
+		if cached[i] {
+			return &PackageResult{Path: pkg.PkgPath, Dir: dir, Cached: true}, nil, nil
+		}
+
+		loaded, ok := b.loaded[pkg.ID]
+		if !ok {
+			return &PackageResult{Path: pkg.PkgPath, Dir: dir}, nil, fmt.Errorf("failed to load package %s", pkg.PkgPath)
+		}
+
+		// Released once decorated, as the batch outlives the package
+		delete(b.loaded, pkg.ID)
+
+		decorated, err := decorate([]*gopackages.Package{loaded})
+		if err != nil {
+			return &PackageResult{Path: pkg.PkgPath, Dir: dir}, nil, errors.Wrapf(err, "failed to decorate package %s", pkg.PkgPath)
+		}
+
+		return p.processPackage(decorated[0])
+	})
+	if err == nil {
+		err = p.record(result, keys)
+	}
+
+	result.Timings.Load = listedAt.Sub(start)
+	result.Timings.Total = time.Since(start)
+
+	return result, err
+}
+
+// record marks the packages in result that converged, i.e. that weren't
+// changed and produced no warnings, in the cache, where keys holds their cache
+// keys, in the same order.
+func (p *Processor) record(result *Result, keys []string) error {
+	// Packages processed in part may not have converged
+	if p.Cache == nil || p.Diff != nil {
+		return nil
+	}
+
+	for i, pkg := range result.Packages {
+		if keys[i] == "" || len(pkg.Changed) > 0 || len(pkg.Deleted) > 0 || len(pkg.Warnings) > 0 {
+			continue
+		}
+
+		if err := p.Cache.markConverged(keys[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// changedPackages returns the packages in pkgs with files in diff.
+func changedPackages(pkgs []*gopackages.Package, diff Diff) (changed []*gopackages.Package) {
+	for _, pkg := range pkgs {
+		for _, filename := range pkg.GoFiles {
+			if diff.Contains(filename) {
+				changed = append(changed, pkg)
+				break
+			}
+		}
 	}
 
-	return ProcessPackages(settings, pkgs, update, getResolver)
+	return changed
 }
 
+// LoadPackages loads the packages matching the patterns, along with their
+// syntax, but without type information. Use a Processor with Types set to
+// type-check them.
 func LoadPackages(packages []string) (pkgs []*decorator.Package, err error) {
-	pkgs, err = decorator.Load(nil, packages...)
+	return (&Processor{}).Load(packages)
+}
+
+// LoadOverlay is like LoadPackages, but loads the contents of overlay, which
+// is keyed by filename, in place of the files on disk.
+func LoadOverlay(packages []string, overlay map[string][]byte) (pkgs []*decorator.Package, err error) {
+	return (&Processor{FS: NewOverlayFS(overlay)}).Load(packages)
+}
+
+// ProcessOverlay is like Process, but loads the contents of overlay, which is
+// keyed by filename, in place of the files on disk, e.g. to instrument unsaved
+// editor buffers. Nothing is written; instead, the new contents of every
+// changed file are returned, keyed by absolute filename. Files removed by a
+// PackageHook are mapped to nil.
+func ProcessOverlay(settings Settings, packages []string, overlay map[string][]byte, update Updater, getResolver func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver) (result *Result, contents map[string][]byte, err error) {
+	fs := NewOverlayFS(overlay)
+	p := &Processor{Settings: settings, Updater: update, GetResolver: getResolver, FS: fs}
+
+	result, err = p.Process(packages)
+	if err != nil {
+		return result, nil, err
+	}
+
+	return result, fs.Changes(), nil
+}
+
+// Load loads the packages matching the patterns from the processor's FS. They
+// are only type-checked if the processor needs type information.
+func (p *Processor) Load(packages []string) (pkgs []*decorator.Package, err error) {
+	cfg := p.config(p.loadMode())
+
+	loaded, err := gopackages.Load(cfg, packages...)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to load packages")
 	}
 
+	pkgs, err = decorate(loaded)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decorate packages")
+	}
+
 	return pkgs, nil
 }
 
-func ProcessPackages(settings Settings, pkgs []*decorator.Package, update func(fn *dst.FuncDecl, shouldSkip bool) (imports []string), getResolver func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver) (err error) {
+// Package loading modes, for listing packages and for loading their syntax with
+// and without type information. Without types, dependencies are only listed,
+// so that their syntax isn't parsed.
+const (
+	listMode   = gopackages.NeedName | gopackages.NeedFiles | gopackages.NeedImports
+	syntaxMode = gopackages.NeedName | gopackages.NeedFiles | gopackages.NeedCompiledGoFiles | gopackages.NeedImports | gopackages.NeedSyntax
+	typesMode  = gopackages.LoadSyntax
+)
+
+// loadMode returns the mode packages must be loaded in for the processor's
+// updater and resolver.
+func (p *Processor) loadMode() gopackages.LoadMode {
+	if p.Types || needsTypes(p.Updater) {
+		return typesMode
+	}
+
+	return syntaxMode
+}
+
+func (p *Processor) config(mode gopackages.LoadMode) *gopackages.Config {
+	return &gopackages.Config{
+		Fset:    token.NewFileSet(),
+		Mode:    mode,
+		Dir:     p.Dir,
+		Overlay: p.fs().Overlay(),
+	}
+}
+
+// list lists the packages matching the patterns, without loading their syntax.
+func (p *Processor) list(packages []string) (pkgs []*gopackages.Package, err error) {
+	pkgs, err = gopackages.Load(p.config(listMode), packages...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load packages")
+	}
+
+	return pkgs, nil
+}
+
+// maxBatch is the most packages Process loads at once. Loading several
+// packages together avoids running the go command for each of them, while
+// keeping memory use bounded.
+const maxBatch = 32
+
+// batchSize returns the number of consecutive packages Process loads at once,
+// spreading n packages across jobs goroutines.
+func batchSize(n, jobs int) int {
+	size := (n + jobs - 1) / jobs
+	if size > maxBatch {
+		size = maxBatch
+	}
+
+	if size < 1 {
+		size = 1
+	}
+
+	return size
+}
+
+// A batch holds listed packages that were loaded together, until each is
+// decorated.
+type batch struct {
+	once sync.Once
+	err  error
+
+	// Loaded packages that are yet to be decorated, keyed by ID
+	loaded map[string]*gopackages.Package
+}
+
+// loadBatch loads the packages in listed into b using a single call to the go
+// command. Excluded packages are skipped, as are those that converged in an
+// earlier run, which are marked in cached. The cache keys of the rest are
+// stored in keys.
+func (p *Processor) loadBatch(b *batch, listed []*gopackages.Package, keys []string, cached []bool) error {
+	cfg := p.config(p.loadMode())
+
+	b.loaded = make(map[string]*gopackages.Package)
+
+	var patterns []string
+
+	for i, pkg := range listed {
+		if _, ok := excludedDir(p.Settings, packageDir(pkg), pkg.Name); ok {
+			continue
+		}
+
+		if p.Cache != nil && version() != "" {
+			key, err := p.Cache.key(p.Settings, p.fs(), pkg)
+			if err != nil {
+				return err
+			}
+
+			if p.Cache.converged(key) {
+				cached[i] = true
+				continue
+			}
+
+			keys[i] = key
+		}
+
+		if pkg.PkgPath == "command-line-arguments" {
+			// Packages named by their files can only be loaded the same way,
+			// and are only listed alone
+			patterns = append(patterns, pkg.GoFiles...)
+		} else {
+			patterns = append(patterns, pkg.PkgPath)
+		}
+	}
+
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	loaded, err := gopackages.Load(cfg, patterns...)
+	if err != nil {
+		return errors.Wrap(err, "failed to load packages")
+	}
+
+	for _, pkg := range loaded {
+		b.loaded[pkg.ID] = pkg
+	}
+
+	return nil
+}
+
+// decorate converts pkgs, along with their dependencies, in the same way as
+// decorator.Load. Unlike decorator.Load, only the syntax of pkgs themselves is
+// decorated. Packages loaded without type information are decorated without it.
+func decorate(pkgs []*gopackages.Package) ([]*decorator.Package, error) {
+	converted := make(map[*gopackages.Package]*decorator.Package)
+
+	out := make([]*decorator.Package, 0, len(pkgs))
 	for _, pkg := range pkgs {
-		var excluded bool
-		for _, pattern := range settings.excludePatterns {
-			if pattern.MatchString(filepath.Join(pkg.Dir, pkg.Name)) {
-				excluded = true
-				break
+		p, err := decorateSyntax(pkg)
+		if err != nil {
+			return nil, err
+		}
+
+		converted[pkg] = p
+		out = append(out, p)
+	}
+
+	// Dependencies that weren't among pkgs are converted without their syntax
+	var link func(p *decorator.Package)
+	link = func(p *decorator.Package) {
+		for path, imp := range p.Package.Imports {
+			dimp, ok := converted[imp]
+			if !ok {
+				dimp = &decorator.Package{Package: imp, Imports: make(map[string]*decorator.Package)}
+				converted[imp] = dimp
+
+				link(dimp)
 			}
+
+			p.Imports[path] = dimp
 		}
+	}
+
+	for _, p := range out {
+		link(p)
+	}
+
+	return out, nil
+}
+
+// decorateSyntax converts pkg, decorating its syntax, but not its imports.
+func decorateSyntax(pkg *gopackages.Package) (*decorator.Package, error) {
+	p := &decorator.Package{
+		Package: pkg,
+		Imports: make(map[string]*decorator.Package),
+	}
+
+	if len(pkg.Syntax) == 0 {
+		return p, nil
+	}
+
+	// Syntax may also hold preprocessed cgo files, which can't be decorated.
+	goFiles := make(map[string]bool, len(pkg.GoFiles))
+	for _, filename := range pkg.GoFiles {
+		goFiles[filename] = true
+	}
+
+	if pkg.TypesInfo != nil {
+		p.Decorator = decorator.NewDecoratorFromPackage(pkg)
+	} else {
+		p.Decorator = decorator.NewDecoratorWithImports(pkg.Fset, pkg.PkgPath, importResolver(pkg))
+	}
+
+	for _, f := range pkg.Syntax {
+		if !goFiles[pkg.Fset.File(f.Pos()).Name()] {
+			continue
+		}
+
+		file, err := p.Decorator.DecorateFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		p.Syntax = append(p.Syntax, file)
+	}
+
+	p.Dir = packageDir(pkg)
+
+	return p, nil
+}
+
+// importResolver returns a resolver for qualified identifiers within pkg. It
+// uses type information if pkg was type-checked, and otherwise the names of
+// its imports, which may not match their paths.
+func importResolver(pkg *gopackages.Package) resolver.DecoratorResolver {
+	if pkg.TypesInfo != nil {
+		return gotypes.New(pkg.TypesInfo.Uses)
+	}
+
+	names := make(map[string]string, len(pkg.Imports))
+	for path, imp := range pkg.Imports {
+		if imp.Name != "" {
+			names[path] = imp.Name
+		}
+	}
+
+	return goast.WithResolver(guess.WithMap(names))
+}
+
+// packageDir returns the directory holding pkg's files, with a trailing
+// separator, as decorator.Load does.
+func packageDir(pkg *gopackages.Package) string {
+	if len(pkg.GoFiles) == 0 {
+		return ""
+	}
+
+	dir, _ := filepath.Split(pkg.GoFiles[0])
+	return dir
+}
+
+// ProcessPackages applies the updater to the already-loaded packages.
+// The returned result is always non-nil, and describes any work completed
+// before an error was encountered. Files are only written once every package
+// has been processed without error. If the updater implements TypesUser, the
+// packages must have been loaded with type information.
+func ProcessPackages(settings Settings, pkgs []*decorator.Package, update Updater, getResolver func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver) (result *Result, err error) {
+	p := &Processor{Settings: settings, Updater: update, GetResolver: getResolver}
+	return p.ProcessPackages(pkgs)
+}
+
+// ProcessPackages is like the package-level ProcessPackages, writing files to
+// the processor's FS.
+func (p *Processor) ProcessPackages(pkgs []*decorator.Package) (result *Result, err error) {
+	return p.run(len(pkgs), 1, func(i int) (*PackageResult, []fileChange, error) {
+		return p.processPackage(pkgs[i])
+	})
+}
+
+// run processes n packages by calling process with each index, claiming size
+// consecutive packages at a time, then writes the changes once all have
+// succeeded.
+func (p *Processor) run(n, size int, process func(i int) (*PackageResult, []fileChange, error)) (result *Result, err error) {
+	result = &Result{}
 
-		if excluded {
+	start := time.Now()
+	defer func() {
+		result.Timings.Process = time.Since(start)
+		result.Timings.Total = result.Timings.Process
+	}()
+
+	// Changed files, in the same order as result.Packages
+	var changed [][]fileChange
+
+	outcomes := p.processAll(n, size, process)
+
+	for _, o := range outcomes {
+		if o.result == nil {
+			// Not started, as another package failed
 			continue
 		}
 
-		// Types to skip, based on trace:skip tags
-		skipTypes := make(map[string]struct{})
+		result.add(o.result)
+
+		if o.err != nil {
+			return result, o.err
+		}
+
+		changed = append(changed, o.changed)
+	}
+
+	for i, files := range changed {
+		for _, f := range files {
+			if f.remove {
+				if err := p.fs().Remove(f.filename); err != nil {
+					return result, errors.Wrapf(err, "failed to remove file %s", f.filename)
+				}
+
+				result.Packages[i].Deleted = append(result.Packages[i].Deleted, f.filename)
+				continue
+			}
+
+			if err := p.fs().WriteFile(f.filename, f.data, 0666); err != nil {
+				return result, errors.Wrapf(err, "failed to save file %s", f.filename)
+			}
+
+			result.Packages[i].Changed = append(result.Packages[i].Changed, f.filename)
+		}
+	}
+
+	return result, nil
+}
+
+// outcome is the result of processing a single package.
+type outcome struct {
+	result  *PackageResult
+	changed []fileChange
+	err     error
+}
 
-		// Types to include, based on trace:enable tags
-		enableTypes := make(map[string]struct{})
+// processAll processes n packages using up to p.Jobs goroutines, returning
+// their outcomes in index order. Each goroutine claims size consecutive
+// packages at a time, and processes them in order. Once a package fails, no
+// further packages are started, and their outcomes are left empty. Packages
+// that were started are always finished.
+func (p *Processor) processAll(n, size int, process func(i int) (*PackageResult, []fileChange, error)) []outcome {
+	outcomes := make([]outcome, n)
+
+	var (
+		wg     sync.WaitGroup
+		next   int64 = -1
+		failed int32
+	)
+
+	for i := 0; i < p.jobs() && i*size < n; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				start := int(atomic.AddInt64(&next, 1)) * size
+				if start >= n {
+					return
+				}
 
-		for _, file := range pkg.Syntax {
-			// Iterate through types first to build the skipTypes map
-			dst.Inspect(file, func(n dst.Node) bool {
-				switch node := n.(type) {
-				case *dst.GenDecl:
-					// Only process types
-					if node.Tok != token.TYPE {
-						return true
+				for i := start; i < start+size && i < n; i++ {
+					// Checked before starting each package, so that every
+					// started package is finished, and any failure is seen
+					// by run
+					if atomic.LoadInt32(&failed) != 0 {
+						return
 					}
 
-					typeName := node.Specs[0].(*dst.TypeSpec).Name.Name
-					if explicitInclude(node.Decs.Start) {
-						enableTypes[typeName] = struct{}{}
-						break
+					o := &outcomes[i]
+					o.result, o.changed, o.err = process(i)
+
+					if o.err == nil && p.Verify && len(o.changed) > 0 {
+						o.err = p.verify(o.result, o.changed)
 					}
 
-					if skipByName(settings, typeName) {
-						skipTypes[typeName] = struct{}{}
-					} else if skipByComments(settings, node.Decs.Start) {
-						skipTypes[typeName] = struct{}{}
-					} else if settings.Tagged {
-						skipTypes[typeName] = struct{}{}
+					if o.err != nil {
+						atomic.StoreInt32(&failed, 1)
 					}
 				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return outcomes
+}
+
+// fileChange holds the new contents of a file, or marks it for removal.
+type fileChange struct {
+	filename string
+	data     []byte
+	remove   bool
+}
+
+func (p *Processor) processPackage(pkg *decorator.Package) (result *PackageResult, changed []fileChange, err error) {
+	settings, update, getResolver := p.Settings, p.Updater, p.GetResolver
 
-				return true
-			})
+	result = &PackageResult{Path: pkg.PkgPath, Dir: pkg.Dir}
+
+	start := time.Now()
+	defer func() {
+		result.Duration = time.Since(start)
+	}()
+
+	if pattern, ok := excluded(settings, pkg); ok {
+		result.Excluded = true
+		result.ExcludedBy = pattern
+
+		return result, nil, nil
+	}
+
+	if pkg.TypesInfo == nil && len(pkg.Syntax) > 0 && needsTypes(update) {
+		return result, nil, fmt.Errorf("%s: the updater needs type information, but the package was loaded without it", pkg.PkgPath)
+	}
+
+	decider := newDecider(settings, pkg.Syntax)
+
+	fileHook, _ := update.(FileHook)
+	packageHook, _ := update.(PackageHook)
+
+	pc := newPackageContext(pkg)
+
+	if packageHook != nil {
+		if err := packageHook.BeforePackage(pc); err != nil {
+			return result, nil, errors.Wrapf(err, "%s", pkg.PkgPath)
 		}
+	}
+
+	for _, file := range pkg.Syntax {
+		var skipped bool
 
-		changed := make(map[string][]byte)
+		// Whether the file's tree may have been modified, in which case it's
+		// rendered and compared with its current contents. Hooks may modify
+		// anything, so files are always rendered if there are any.
+		dirty := fileHook != nil
 
-		for _, file := range pkg.Syntax {
-			resolver := getResolver(pkg, file)
+		imports := make(map[string]struct{})
 
-			pre, err := fileContents(pkg, file, resolver)
+		fileCtx := &FileContext{
+			File:     file,
+			Filename: pkg.Decorator.Filenames[file],
+			Package:  pkg,
+			PkgPath:  pkg.PkgPath,
+		}
+
+		if fileHook != nil {
+			imps, err := fileHook.BeforeFile(fileCtx)
 			if err != nil {
-				return err
+				return result, nil, errors.Wrapf(err, "%s", fileCtx.Filename)
 			}
 
-			var skipped bool
+			for _, imp := range imps {
+				imports[imp] = struct{}{}
+			}
+		}
+
+		// Whether each function was modified by the updater, and the decision
+		// made for it.
+		var modified, skips []bool
 
-			imports := make(map[string]struct{})
+		// Iterate through functions next
+		dst.Inspect(file, func(n dst.Node) bool {
+			// Returning false only stops descent, so siblings of a failed
+			// function would otherwise still be updated
+			if err != nil {
+				return false
+			}
 
-			// Iterate through functions next
-			dst.Inspect(file, func(n dst.Node) bool {
-				switch node := n.(type) {
-				case *dst.FuncDecl:
-					// Whether this function should be skipped
-					var shouldSkip bool
+			switch node := n.(type) {
+			case *dst.FuncDecl:
+				if p.Diff != nil && !touched(p.Diff, pkg, file, node) {
+					return true
+				}
 
-					defer func() {
-						if shouldSkip {
-							skipped = true
-						}
-					}()
+				fc := newFuncContext(pkg, file, node, decider)
+				if fc.ShouldSkip {
+					skipped = true
+				}
 
-					// Whether this function should explicitly be included
-					shouldInclude := !settings.Tagged
+				before := signature(node)
 
-					if skipByName(settings, node.Name.Name) {
-						shouldSkip = true
-					}
+				imps, updateErr := update.Update(fc)
 
-					if skipByComments(settings, node.Decs.Start) {
-						shouldSkip = true
-					}
+				for _, warning := range fc.warnings {
+					f := newFinding(RuleUpdater, fmt.Sprintf("%s: %s", funcName(node), warning), fc.Position())
+					f.Level = LevelWarning
 
-					// Check for a struct-level skip tag
-					if node.Recv != nil {
-						for _, field := range node.Recv.List {
-							typeName := typeNameFromFieldExpr(field.Type)
-
-							if typeName != "" {
-								if _, include := enableTypes[typeName]; include {
-									shouldInclude = true
-								} else if _, skip := skipTypes[typeName]; skip {
-									shouldSkip = true
-								}
-							}
-						}
-					} else if settings.Methods {
-						shouldSkip = true
-					}
+					result.Warnings = append(result.Warnings, f)
+				}
 
-					if explicitInclude(node.Decs.Start) {
-						shouldSkip = false
-					} else if settings.Tagged && !shouldSkip {
-						shouldSkip = !shouldInclude
-					}
+				if updateErr != nil {
+					err = &UpdateError{Pos: fc.Position(), Func: funcName(node), Err: updateErr}
+					return false
+				}
 
-					for _, imp := range update(node, shouldSkip) {
-						imports[imp] = struct{}{}
-					}
+				for _, imp := range imps {
+					imports[imp] = struct{}{}
 				}
 
-				return true
-			})
+				modified = append(modified, signature(node) != before)
+				skips = append(skips, fc.ShouldSkip)
 
-			if !skipped {
-				for imp := range imports {
-					addImport(pkg, file, imp)
+				if modified[len(modified)-1] {
+					dirty = true
 				}
 			}
 
-			post, err := fileContents(pkg, file, resolver)
+			return err == nil
+		})
+
+		if err != nil {
+			return result, nil, err
+		}
+
+		if fileHook != nil {
+			imps, err := fileHook.AfterFile(fileCtx)
 			if err != nil {
-				return err
+				return result, nil, errors.Wrapf(err, "%s", fileCtx.Filename)
 			}
 
-			if !bytes.Equal(pre, post) {
-				changed[pkg.Decorator.Filenames[file]] = post
+			for _, imp := range imps {
+				imports[imp] = struct{}{}
 			}
 		}
 
-		for filename, data := range changed {
-			if err := writer(filename, data, 0666); err != nil {
-				return errors.Wrapf(err, "failed to save file %s", filename)
+		if !skipped {
+			for imp := range imports {
+				if addImport(pkg, file, imp) {
+					dirty = true
+				}
+			}
+		}
+
+		var fileChanged bool
+
+		if dirty {
+			filename := pkg.Decorator.Filenames[file]
+
+			data, err := fileContents(pkg, file, getResolver(pkg, file))
+			if err != nil {
+				return result, nil, err
+			}
+
+			// The tree may have been changed and then restored, e.g. by
+			// replacing a statement with an identical one
+			original, err := p.fs().ReadFile(filename)
+			if err != nil {
+				return result, nil, errors.Wrapf(err, "failed to read file %s", filename)
+			}
+
+			fileChanged = !bytes.Equal(original, data)
+			if fileChanged {
+				changed = append(changed, fileChange{filename: filename, data: data})
+			}
+		}
+
+		for i := range modified {
+			switch {
+			case !fileChanged || !modified[i]:
+				result.Unchanged++
+			case skips[i]:
+				result.Removed++
+			default:
+				result.Instrumented++
 			}
 		}
 	}
 
-	return nil
+	if packageHook != nil {
+		if err := packageHook.AfterPackage(pc); err != nil {
+			return result, nil, errors.Wrapf(err, "%s", pkg.PkgPath)
+		}
+
+		if changed, err = p.applyPackageContext(pc, changed); err != nil {
+			return result, nil, err
+		}
+	}
+
+	sort.Slice(changed, func(i, j int) bool {
+		return changed[i].filename < changed[j].filename
+	})
+
+	return result, changed, nil
+}
+
+// applyPackageContext merges the files set or removed by a PackageHook into
+// changed, omitting any that match what's already in the processor's FS.
+func (p *Processor) applyPackageContext(pc *PackageContext, changed []fileChange) ([]fileChange, error) {
+	for _, filename := range pc.filenames() {
+		// Files set or removed by the hook take precedence over any changes
+		// made while processing the package.
+		for i, f := range changed {
+			if f.filename == filename {
+				changed = append(changed[:i], changed[i+1:]...)
+				break
+			}
+		}
+
+		existing, err := p.fs().ReadFile(filename)
+		exists := err == nil
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "failed to read file %s", filename)
+		}
+
+		file := pc.files[filename]
+		if file == nil {
+			if exists {
+				changed = append(changed, fileChange{filename: filename, remove: true})
+			}
+
+			continue
+		}
+
+		data, err := fileContents(pc.Package, file, p.GetResolver(pc.Package, file))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to render file %s", filename)
+		}
+
+		if !exists || !bytes.Equal(existing, data) {
+			changed = append(changed, fileChange{filename: filename, data: data})
+		}
+	}
+
+	return changed, nil
+}
+
+// inspectFuncs calls fn for every function declaration within pkg, along with
+// the file containing it and the skip decision made for it.
+func inspectFuncs(settings Settings, pkg *decorator.Package, fn func(file *dst.File, node *dst.FuncDecl, shouldSkip bool)) {
+	decider := newDecider(settings, pkg.Syntax)
+
+	for _, file := range pkg.Syntax {
+		dst.Inspect(file, func(n dst.Node) bool {
+			if node, ok := n.(*dst.FuncDecl); ok {
+				fn(file, node, decider.shouldSkip(node))
+			}
+
+			return true
+		})
+	}
+}
+
+// excluded reports whether pkg matches any of the exclude patterns, and if so,
+// which one.
+func excluded(settings Settings, pkg *decorator.Package) (pattern string, ok bool) {
+	return excludedDir(settings, pkg.Dir, pkg.Name)
+}
+
+// excludedDir is like excluded, for the package with the given directory and
+// name.
+func excludedDir(settings Settings, dir, name string) (pattern string, ok bool) {
+	for _, pattern := range settings.excludePatterns {
+		if pattern.MatchString(filepath.Join(dir, name)) {
+			return pattern.String(), true
+		}
+	}
+
+	return "", false
 }
 
 func fileContents(p *decorator.Package, file *dst.File, resolver resolver.RestorerResolver) (data []byte, err error) {
@@ -193,6 +914,75 @@ func fileContents(p *decorator.Package, file *dst.File, resolver resolver.Restor
 	return buf.Bytes(), nil
 }
 
+// signature summarises node's tree, used to detect whether an updater modified
+// it. It covers the identity of each node, along with the names, values and
+// decorations that updaters typically change in place, and is far cheaper to
+// compute than rendering the tree. Other fields changed in place, such as an
+// operator, go unnoticed.
+func signature(node dst.Node) uint64 {
+	h := fnv.New64a()
+
+	var buf [8]byte
+
+	dst.Inspect(node, func(n dst.Node) bool {
+		if n == nil {
+			return false
+		}
+
+		binary.LittleEndian.PutUint64(buf[:], uint64(reflect.ValueOf(n).Pointer()))
+		h.Write(buf[:])
+
+		switch n := n.(type) {
+		case *dst.Ident:
+			io.WriteString(h, n.Name)
+			io.WriteString(h, n.Path)
+		case *dst.BasicLit:
+			io.WriteString(h, n.Value)
+		}
+
+		decs := n.Decorations()
+		for _, d := range [][]string{decs.Start, decs.End} {
+			for _, s := range d {
+				io.WriteString(h, s)
+			}
+
+			h.Write([]byte{0})
+		}
+
+		h.Write([]byte{byte(decs.Before), byte(decs.After)})
+
+		return true
+	})
+
+	return h.Sum64()
+}
+
+// touched reports whether diff touches node, one of file's functions, or its
+// doc comment.
+func touched(diff Diff, pkg *decorator.Package, file *dst.File, node *dst.FuncDecl) bool {
+	n, ok := pkg.Decorator.Ast.Nodes[node].(*ast.FuncDecl)
+	if !ok {
+		return false
+	}
+
+	start := n.Pos()
+	if n.Doc != nil {
+		start = n.Doc.Pos()
+	}
+
+	return diff.Overlaps(pkg.Decorator.Filenames[file], pkg.Fset.Position(start).Line, pkg.Fset.Position(n.End()).Line)
+}
+
+// fingerprintFilter omits objects and scopes, which can reach far beyond the
+// node being fingerprinted.
+func fingerprintFilter(name string, value reflect.Value) bool {
+	if name == "Obj" || name == "Scope" {
+		return false
+	}
+
+	return dst.NotNilFilter(name, value)
+}
+
 func typeNameFromFieldExpr(expr dst.Expr) string {
 	switch expr := expr.(type) {
 	case *dst.Ident:
@@ -205,3 +995,24 @@ func typeNameFromFieldExpr(expr dst.Expr) string {
 
 	return ""
 }
+
+// position returns the start and end positions of node within its package's
+// file set.
+func position(pkg *decorator.Package, node dst.Node) (start, end token.Position) {
+	n, ok := pkg.Decorator.Ast.Nodes[node]
+	if !ok {
+		return start, end
+	}
+
+	return pkg.Fset.Position(n.Pos()), pkg.Fset.Position(n.End())
+}
+
+// receiverName returns the name of fn's receiver type, or an empty string if
+// fn is not a method.
+func receiverName(fn *dst.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return ""
+	}
+
+	return typeNameFromFieldExpr(fn.Recv.List[0].Type)
+}
@@ -1,9 +1,12 @@
 package tracegen
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/dave/dst"
@@ -116,13 +119,13 @@ func TestProcess(t *testing.T) {
 
 			var calls []bool
 
-			err = Process(
+			_, err = Process(
 				test.settings,
 				[]string{"."},
-				func(fn *dst.FuncDecl, shouldSkip bool) (imports []string) {
+				UpdateFunc(func(fn *dst.FuncDecl, shouldSkip bool) (imports []string) {
 					calls = append(calls, shouldSkip)
 					return nil
-				},
+				}),
 				func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
 					return NewSimpleResolver(pkg, file, nil)
 				},
@@ -135,3 +138,520 @@ func TestProcess(t *testing.T) {
 		})
 	}
 }
+
+const resultInput = `package main
+
+func A() {}
+
+//trace:skip
+func B() {
+	println()
+}
+
+func C() {
+	println()
+}
+`
+
+const resultOutput = `package main
+
+func A() {
+	println()
+}
+
+//trace:skip
+func B() {}
+
+func C() {
+	println()
+}
+`
+
+// updateBody ensures the body consists of a single println call, or is empty
+// if skipped.
+func updateBody(fn *dst.FuncDecl, shouldSkip bool) (imports []string) {
+	if shouldSkip {
+		fn.Body.List = nil
+	} else if len(fn.Body.List) == 0 {
+		fn.Body.List = []dst.Stmt{
+			&dst.ExprStmt{
+				X:    &dst.CallExpr{Fun: dst.NewIdent("println")},
+				Decs: dst.ExprStmtDecorations{NodeDecs: dst.NodeDecs{Before: dst.NewLine, After: dst.NewLine}},
+			},
+		}
+	}
+
+	return nil
+}
+
+func TestProcessResult(t *testing.T) {
+	path := writeModule(t, resultInput)
+	err := os.Chdir(filepath.Dir(path))
+	check(t, err)
+
+	getResolver := func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+		return NewSimpleResolver(pkg, file, nil)
+	}
+
+	result, err := Process(Settings{}, []string{"."}, UpdateFunc(updateBody), getResolver)
+	check(t, err)
+
+	if result.Instrumented != 1 || result.Removed != 1 || result.Unchanged != 1 {
+		t.Fatalf("mismatched counts, got %d/%d/%d instrumented/removed/unchanged, expected 1/1/1", result.Instrumented, result.Removed, result.Unchanged)
+	}
+
+	if changed := result.Changed(); len(changed) != 1 || filepath.Base(changed[0]) != "sample.go" {
+		t.Fatalf("mismatched changed files, got %v", changed)
+	}
+
+	data, err := os.ReadFile(path)
+	check(t, err)
+
+	if string(data) != resultOutput {
+		t.Fatalf("mismatched output:\ngot:\n%s\nexpected:\n%s", string(data), resultOutput)
+	}
+
+	// A second run should change nothing.
+	result, err = Process(Settings{}, []string{"."}, UpdateFunc(updateBody), getResolver)
+	check(t, err)
+
+	if result.Unchanged != 3 || len(result.Changed()) != 0 {
+		t.Fatalf("expected no changes on second run, got %+v", result.Packages[0])
+	}
+
+	settings := Settings{Exclude: []string{`main$`}}
+	check(t, settings.Parse())
+
+	result, err = Process(settings, []string{"."}, UpdateFunc(updateBody), getResolver)
+	check(t, err)
+
+	if pkg := result.Packages[0]; !pkg.Excluded || pkg.ExcludedBy != `main$` {
+		t.Fatalf("expected package to be excluded by main$, got %+v", pkg)
+	}
+}
+
+const contextInput = `package main
+
+//trace:skip
+type Skipped struct{}
+
+func (s *Skipped) Method() {}
+
+type Enabled struct{}
+
+//trace:enable
+func (e Enabled) Method() {}
+
+func unexported() {}
+`
+
+// recorder is an Updater that records the contexts it's invoked with.
+type recorder []*FuncContext
+
+func (r *recorder) Update(fc *FuncContext) (imports []string, err error) {
+	*r = append(*r, fc)
+	return nil, nil
+}
+
+func (r *recorder) NeedsTypes() bool {
+	return true
+}
+
+func TestProcessFuncContext(t *testing.T) {
+	path := writeModule(t, contextInput)
+	err := os.Chdir(filepath.Dir(path))
+	check(t, err)
+
+	var r recorder
+
+	_, err = Process(Settings{Exported: true}, []string{"."}, &r, func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+		return NewSimpleResolver(pkg, file, nil)
+	})
+	check(t, err)
+
+	type call struct {
+		Receiver   string
+		Directives []string
+		ShouldSkip bool
+		SkipReason string
+	}
+
+	var got []call
+	for _, fc := range r {
+		if fc.PkgPath != "test" || filepath.Base(fc.Filename) != "sample.go" || fc.TypesInfo == nil {
+			t.Fatalf("unexpected package details in %+v", fc)
+		}
+
+		got = append(got, call{fc.Receiver, fc.Directives, fc.ShouldSkip, fc.SkipReason})
+	}
+
+	expected := []call{
+		{"Skipped", nil, true, "type Skipped is tagged trace:skip"},
+		{"Enabled", []string{"trace:enable"}, false, ""},
+		{"", nil, true, "function is not exported"},
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("mismatched contexts, got %+v, expected %+v", got, expected)
+	}
+}
+
+func TestProcessWithoutTypes(t *testing.T) {
+	path := writeModule(t, contextInput)
+	err := os.Chdir(filepath.Dir(path))
+	check(t, err)
+
+	// Unlike recorder itself, the wrapper doesn't implement TypesUser
+	var r recorder
+
+	_, err = Process(Settings{}, []string{"."}, struct{ Updater }{&r}, func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+		return NewSimpleResolver(pkg, file, nil)
+	})
+	check(t, err)
+
+	if len(r) != 3 {
+		t.Fatalf("expected 3 calls, got %d", len(r))
+	}
+
+	for _, fc := range r {
+		if fc.TypesInfo != nil {
+			t.Fatalf("expected %s to be loaded without types", fc.PkgPath)
+		}
+	}
+}
+
+func TestProcessPackagesRequiresTypes(t *testing.T) {
+	path := writeModule(t, contextInput)
+	err := os.Chdir(filepath.Dir(path))
+	check(t, err)
+
+	pkgs, err := LoadPackages([]string{"."})
+	check(t, err)
+
+	var r recorder
+
+	_, err = ProcessPackages(Settings{}, pkgs, &r, func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+		return NewSimpleResolver(pkg, file, nil)
+	})
+	if err == nil || len(r) != 0 {
+		t.Fatalf("expected an error before the updater was called, got %v after %d calls", err, len(r))
+	}
+}
+
+func TestProcessRendersModifiedFiles(t *testing.T) {
+	path := writeModule(t, "package main\n\nfunc A() {}\n")
+	err := os.Chdir(filepath.Dir(path))
+	check(t, err)
+
+	err = os.WriteFile("other.go", []byte("package main\n\nfunc B() {}\n"), 0644)
+	check(t, err)
+
+	var rendered []string
+
+	result, err := Process(Settings{}, []string{"."}, UpdateFunc(func(fn *dst.FuncDecl, shouldSkip bool) (imports []string) {
+		if fn.Name.Name == "A" && len(fn.Body.List) == 0 {
+			fn.Body.List = append(fn.Body.List, &dst.ReturnStmt{})
+		}
+
+		return nil
+	}), func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+		rendered = append(rendered, filepath.Base(pkg.Decorator.Filenames[file]))
+		return NewSimpleResolver(pkg, file, nil)
+	})
+	check(t, err)
+
+	if !reflect.DeepEqual(rendered, []string{"sample.go"}) {
+		t.Fatalf("expected only the modified file to be rendered, got %v", rendered)
+	}
+
+	if changed := result.Changed(); len(changed) != 1 || changed[0] != path {
+		t.Fatalf("expected %s to change, got %v", path, changed)
+	}
+}
+
+const diagnosticsInput = `package main
+
+func Warn() {}
+`
+
+const diagnosticsPackage = `package other
+
+func Other() {}
+
+func Fail() {}
+`
+
+// failingUpdater empties every body, but fails on Fail and FailAgain, and
+// warns about Warn.
+type failingUpdater struct{}
+
+func (failingUpdater) Update(fc *FuncContext) (imports []string, err error) {
+	switch fc.Func.Name.Name {
+	case "Fail", "FailAgain":
+		return nil, errors.New("cannot rewrite")
+	case "Warn":
+		fc.Warnf("rewritten with caveats")
+	}
+
+	return updateBody(fc.Func, false), nil
+}
+
+func TestProcessUpdaterDiagnostics(t *testing.T) {
+	path := writeModule(t, diagnosticsInput)
+	dir := filepath.Dir(path)
+
+	err := os.Mkdir(filepath.Join(dir, "other"), 0755)
+	check(t, err)
+
+	other := filepath.Join(dir, "other", "other.go")
+	err = os.WriteFile(other, []byte(diagnosticsPackage), 0644)
+	check(t, err)
+
+	err = os.Chdir(dir)
+	check(t, err)
+
+	result, err := Process(Settings{}, []string{".", "./other"}, failingUpdater{}, func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+		return NewSimpleResolver(pkg, file, nil)
+	})
+
+	var updateErr *UpdateError
+	if !errors.As(err, &updateErr) {
+		t.Fatalf("expected an UpdateError, got %v", err)
+	}
+
+	if updateErr.Func != "Fail" || updateErr.Pos.Line != 5 {
+		t.Fatalf("unexpected error context: %v", updateErr)
+	}
+
+	if len(result.Warnings) != 1 || result.Warnings[0].Line != 3 || result.Warnings[0].Message != "Warn: rewritten with caveats" {
+		t.Fatalf("unexpected warnings: %+v", result.Warnings)
+	}
+
+	// Neither package should have been written, even though the first was
+	// processed successfully.
+	for filename, expected := range map[string]string{path: diagnosticsInput, other: diagnosticsPackage} {
+		data, err := os.ReadFile(filename)
+		check(t, err)
+
+		if string(data) != expected {
+			t.Fatalf("%s was modified despite the error:\n%s", filename, string(data))
+		}
+	}
+}
+
+const failuresInput = `package main
+
+func Fail() {}
+
+func FailAgain() {}
+
+func After() {}
+`
+
+func TestProcessStopsAtFirstUpdateError(t *testing.T) {
+	path := writeModule(t, failuresInput)
+
+	var calls int
+
+	p := &Processor{
+		Updater: countingUpdater{Updater: failingUpdater{}, calls: &calls},
+		GetResolver: func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+			return NewSimpleResolver(pkg, file, nil)
+		},
+		Dir: filepath.Dir(path),
+	}
+
+	_, err := p.Process([]string{"."})
+
+	var updateErr *UpdateError
+	if !errors.As(err, &updateErr) {
+		t.Fatalf("expected an UpdateError, got %v", err)
+	}
+
+	if updateErr.Func != "Fail" || updateErr.Pos.Line != 3 {
+		t.Fatalf("expected the first failure to be reported, got %v", updateErr)
+	}
+
+	if calls != 1 {
+		t.Fatalf("expected no functions to be updated after the failure, got %d calls", calls)
+	}
+}
+
+func TestProcessJobs(t *testing.T) {
+	path := writeModule(t, compositeInput)
+	dir := filepath.Dir(path)
+
+	err := os.Chdir(dir)
+	check(t, err)
+
+	var expected []string
+
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprintf("pkg%d", i)
+
+		err := os.Mkdir(filepath.Join(dir, name), 0755)
+		check(t, err)
+
+		err = os.WriteFile(filepath.Join(dir, name, "sample.go"), []byte(strings.Replace(compositeInput, "main", name, 1)), 0644)
+		check(t, err)
+
+		expected = append(expected, "test/"+name)
+	}
+
+	p := &Processor{
+		Updater: Composite{printlnUpdater{name: "first"}, printlnUpdater{name: "second"}},
+		GetResolver: func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+			return NewSimpleResolver(pkg, file, nil)
+		},
+		Jobs: 4,
+	}
+
+	result, err := p.Process([]string{"./pkg..."})
+	check(t, err)
+
+	var paths []string
+	for _, pkg := range result.Packages {
+		paths = append(paths, pkg.Path)
+
+		if len(pkg.Changed) != 1 {
+			t.Fatalf("expected 1 changed file in %s, got %v", pkg.Path, pkg.Changed)
+		}
+	}
+
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("mismatched package order, got %v, expected %v", paths, expected)
+	}
+
+	if result.Instrumented != len(expected) {
+		t.Fatalf("expected %d instrumented functions, got %d", len(expected), result.Instrumented)
+	}
+
+	// An error from any package fails the run
+	p.Updater = errUpdater{}
+
+	_, err = p.Process([]string{"./pkg..."})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// Including a later one, in which case nothing is written
+	contents := make(map[string]string)
+	for i := 0; i < 8; i++ {
+		filename := filepath.Join(dir, fmt.Sprintf("pkg%d", i), "sample.go")
+
+		data, err := os.ReadFile(filename)
+		check(t, err)
+
+		contents[filename] = string(data)
+	}
+
+	p.Updater = packageFailingUpdater{Updater: printlnUpdater{name: "third"}, pkgPath: "test/pkg7"}
+
+	_, err = p.Process([]string{"./pkg..."})
+
+	var updateErr *UpdateError
+	if !errors.As(err, &updateErr) {
+		t.Fatalf("expected an UpdateError, got %v", err)
+	}
+
+	for filename, expected := range contents {
+		data, err := os.ReadFile(filename)
+		check(t, err)
+
+		if string(data) != expected {
+			t.Fatalf("%s was modified despite the error:\n%s", filename, string(data))
+		}
+	}
+}
+
+// packageFailingUpdater fails on functions in pkgPath, and applies its updater to
+// the rest.
+type packageFailingUpdater struct {
+	Updater
+	pkgPath string
+}
+
+func (u packageFailingUpdater) Update(fc *FuncContext) (imports []string, err error) {
+	if fc.PkgPath == u.pkgPath {
+		return nil, errors.New("failed")
+	}
+
+	return u.Updater.Update(fc)
+}
+
+func TestBatchSize(t *testing.T) {
+	tests := []struct {
+		n, jobs, expected int
+	}{
+		{0, 4, 1},
+		{3, 4, 1},
+		{8, 4, 2},
+		{9, 4, 3},
+		{10, 1, 10},
+		{1000, 4, maxBatch},
+	}
+
+	for _, test := range tests {
+		if size := batchSize(test.n, test.jobs); size != test.expected {
+			t.Fatalf("expected a batch size of %d for %d packages across %d jobs, got %d", test.expected, test.n, test.jobs, size)
+		}
+	}
+}
+
+const mismatchInput = `package main
+
+import "test/bar"
+
+func Foo() {
+	baz.X()
+}
+`
+
+const mismatchOutput = `package main
+
+import "test/bar"
+
+func Foo() {
+	println("traced")
+	baz.X()
+}
+`
+
+// writeMismatchedModule writes mismatchInput to a module along with the
+// package it imports, whose name doesn't match its directory.
+func writeMismatchedModule(t *testing.T) (path string) {
+	t.Helper()
+
+	path = writeModule(t, mismatchInput)
+	dir := filepath.Join(filepath.Dir(path), "bar")
+
+	err := os.Mkdir(dir, 0755)
+	check(t, err)
+
+	err = os.WriteFile(filepath.Join(dir, "bar.go"), []byte("package baz\n\nfunc X() {}\n"), 0644)
+	check(t, err)
+
+	return path
+}
+
+func TestProcessPackageNameMismatch(t *testing.T) {
+	path := writeMismatchedModule(t)
+
+	p := &Processor{
+		Updater: printlnUpdater{name: "traced"},
+		GetResolver: func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+			return NewSimpleResolver(pkg, file, nil)
+		},
+		Dir: filepath.Dir(path),
+	}
+
+	_, err := p.Process([]string{"."})
+	check(t, err)
+
+	data, err := os.ReadFile(path)
+	check(t, err)
+
+	if string(data) != mismatchOutput {
+		t.Fatalf("mismatched output:\ngot:\n%s\nexpected:\n%s", string(data), mismatchOutput)
+	}
+}
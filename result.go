@@ -0,0 +1,82 @@
+package tracegen
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Result describes what a run of Process or ProcessPackages did.
+type Result struct {
+	Packages []*PackageResult `json:"packages"`
+
+	Instrumented int `json:"instrumented"`
+	Removed      int `json:"removed"`
+	Unchanged    int `json:"unchanged"`
+
+	// Warnings reported by the updater.
+	Warnings []Finding `json:"warnings,omitempty"`
+
+	Timings Timings `json:"timings"`
+}
+
+// PackageResult describes what was done to a single loaded package.
+type PackageResult struct {
+	Path string `json:"path"`
+	Dir  string `json:"dir"`
+
+	// ExcludedBy is the exclude pattern that matched the package, if any.
+	Excluded   bool   `json:"excluded"`
+	ExcludedBy string `json:"excluded_by,omitempty"`
+
+	// Cached is set if the package was skipped, having converged in an
+	// earlier run.
+	Cached bool `json:"cached,omitempty"`
+
+	// Changed lists the files that were written, and Deleted those that were
+	// removed.
+	Changed []string `json:"changed,omitempty"`
+	Deleted []string `json:"deleted,omitempty"`
+
+	// Functions for which the updater added or updated its code, removed its
+	// code, or made no changes.
+	Instrumented int `json:"instrumented"`
+	Removed      int `json:"removed"`
+	Unchanged    int `json:"unchanged"`
+
+	Warnings []Finding `json:"warnings,omitempty"`
+
+	Duration time.Duration `json:"duration"`
+}
+
+// Timings are recorded in nanoseconds when encoded as JSON.
+type Timings struct {
+	Load    time.Duration `json:"load"`
+	Process time.Duration `json:"process"`
+	Total   time.Duration `json:"total"`
+}
+
+// Changed returns every file rewritten during the run.
+func (r *Result) Changed() (files []string) {
+	for _, pkg := range r.Packages {
+		files = append(files, pkg.Changed...)
+	}
+
+	return files
+}
+
+// WriteJSON writes the result as an indented JSON document.
+func (r *Result) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(r)
+}
+
+func (r *Result) add(pkg *PackageResult) {
+	r.Packages = append(r.Packages, pkg)
+	r.Instrumented += pkg.Instrumented
+	r.Removed += pkg.Removed
+	r.Unchanged += pkg.Unchanged
+	r.Warnings = append(r.Warnings, pkg.Warnings...)
+}
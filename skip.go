@@ -1,14 +1,24 @@
 package tracegen
 
 import (
+	"fmt"
+	"go/token"
 	"regexp"
 
 	"github.com/dave/dst"
 )
 
 var (
-	skipPattern    = regexp.MustCompile(`//\s*trace:skip`)
-	includePattern = regexp.MustCompile(`//\s*trace:enable`)
+	skipPattern    = regexp.MustCompile(`^//\s*trace:skip\b`)
+	includePattern = regexp.MustCompile(`^//\s*trace:enable\b`)
+
+	// Any trace:<name> comment, used to detect unknown directives
+	directivePattern = regexp.MustCompile(`^//\s*trace:(\w+)`)
+
+	knownDirectives = map[string]struct{}{
+		"skip":   {},
+		"enable": {},
+	}
 )
 
 func skipByName(c Settings, name string) bool {
@@ -25,6 +35,17 @@ func explicitInclude(decs []string) bool {
 	return false
 }
 
+// directives returns the trace directives (e.g. "trace:skip") found in decs.
+func directives(decs []string) (names []string) {
+	for _, dec := range decs {
+		if m := directivePattern.FindStringSubmatch(dec); m != nil {
+			names = append(names, "trace:"+m[1])
+		}
+	}
+
+	return names
+}
+
 func skipByComments(c Settings, decs []string) bool {
 	if explicitInclude(decs) {
 		return false
@@ -38,3 +59,107 @@ func skipByComments(c Settings, decs []string) bool {
 
 	return false
 }
+
+// decider makes per-function skip decisions for a single package, taking into
+// account any type-level tags found within the package.
+type decider struct {
+	settings Settings
+
+	// Types to skip, based on trace:skip tags, along with the reason
+	skipTypes map[string]string
+
+	// Types to include, based on trace:enable tags
+	enableTypes map[string]struct{}
+}
+
+func newDecider(settings Settings, files []*dst.File) *decider {
+	d := &decider{
+		settings:    settings,
+		skipTypes:   make(map[string]string),
+		enableTypes: make(map[string]struct{}),
+	}
+
+	for _, file := range files {
+		// Iterate through types first to build the skipTypes map
+		dst.Inspect(file, func(n dst.Node) bool {
+			switch node := n.(type) {
+			case *dst.GenDecl:
+				// Only process types
+				if node.Tok != token.TYPE {
+					return true
+				}
+
+				typeName := node.Specs[0].(*dst.TypeSpec).Name.Name
+				if explicitInclude(node.Decs.Start) {
+					d.enableTypes[typeName] = struct{}{}
+					break
+				}
+
+				if skipByName(settings, typeName) {
+					d.skipTypes[typeName] = fmt.Sprintf("type %s is not exported", typeName)
+				} else if skipByComments(settings, node.Decs.Start) {
+					d.skipTypes[typeName] = fmt.Sprintf("type %s is tagged trace:skip", typeName)
+				} else if settings.Tagged {
+					d.skipTypes[typeName] = fmt.Sprintf("type %s is not tagged trace:enable", typeName)
+				}
+			}
+
+			return true
+		})
+	}
+
+	return d
+}
+
+// shouldSkip reports whether the updater should remove (rather than inject)
+// its code for the given function.
+func (d *decider) shouldSkip(node *dst.FuncDecl) bool {
+	skip, _ := d.decide(node)
+	return skip
+}
+
+// decide is like shouldSkip, but also returns a human-readable reason for
+// skipping the function.
+func (d *decider) decide(node *dst.FuncDecl) (shouldSkip bool, reason string) {
+	skip := func(r string) {
+		if !shouldSkip {
+			shouldSkip, reason = true, r
+		}
+	}
+
+	// Whether this function should explicitly be included
+	shouldInclude := !d.settings.Tagged
+
+	if skipByName(d.settings, node.Name.Name) {
+		skip("function is not exported")
+	}
+
+	if skipByComments(d.settings, node.Decs.Start) {
+		skip("function is tagged trace:skip")
+	}
+
+	// Check for a struct-level skip tag
+	if node.Recv != nil {
+		for _, field := range node.Recv.List {
+			typeName := typeNameFromFieldExpr(field.Type)
+
+			if typeName != "" {
+				if _, include := d.enableTypes[typeName]; include {
+					shouldInclude = true
+				} else if r, ok := d.skipTypes[typeName]; ok {
+					skip(r)
+				}
+			}
+		}
+	} else if d.settings.Methods {
+		skip("function is not a method")
+	}
+
+	if explicitInclude(node.Decs.Start) {
+		shouldSkip, reason = false, ""
+	} else if d.settings.Tagged && !shouldSkip && !shouldInclude {
+		skip("function is not tagged trace:enable")
+	}
+
+	return shouldSkip, reason
+}
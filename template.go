@@ -0,0 +1,418 @@
+package tracegen
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver/goast"
+	"github.com/dave/dst/decorator/resolver/guess"
+	"github.com/pkg/errors"
+)
+
+// Placeholders recognised within templates. The identifiers are replaced with
+// the names of the function's context parameter and receiver, and the
+// remainder are replaced within string literals.
+const (
+	PlaceholderCtx  = "__ctx__"
+	PlaceholderRecv = "__recv__"
+	PlaceholderSpan = "__span__"
+	PlaceholderFunc = "__func__"
+	PlaceholderType = "__type__"
+)
+
+// wildcard stands in for placeholders when matching existing statements, as
+// their values may have changed since the statements were generated.
+const wildcard = "__tracegen__"
+
+// TemplateUpdater is an Updater whose statements are defined by a template Go
+// file, rather than by hand-written dst code. The template must contain a
+// function whose first parameter is named __ctx__, and whose body holds the
+// statements to insert at the start of eligible functions, e.g.
+//
+//	package template
+//
+//	import (
+//		"context"
+//
+//		"github.com/opentracing/opentracing-go"
+//	)
+//
+//	func _(__ctx__ context.Context) {
+//		span, __ctx__ := opentracing.StartSpanFromContext(__ctx__, "__span__")
+//		defer span.Finish()
+//	}
+//
+// A function is eligible if its first parameter has the same type as the
+// template's. Within string literals, __span__ is replaced with the function's
+// name qualified by its receiver type (e.g. "Server.Handle"), __func__ with
+// its bare name and __type__ with its receiver type. The __recv__ identifier is
+// replaced with the name of the function's receiver.
+//
+// Existing statements are recognised regardless of the placeholders' values,
+// so that e.g. a renamed function has its span name updated rather than
+// gaining a second set of statements.
+type TemplateUpdater struct {
+	param   dst.Expr
+	stmts   []dst.Stmt
+	imports []string
+	hints   map[string]string
+
+	// Dumps of the template's statements with placeholders replaced by
+	// wildcards, for matching.
+	patterns [][]string
+}
+
+// LoadTemplateUpdater reads a template from the named file.
+func LoadTemplateUpdater(filename string) (*TemplateUpdater, error) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read template")
+	}
+
+	return NewTemplateUpdater(src)
+}
+
+// NewTemplateUpdater parses a template from src.
+func NewTemplateUpdater(src []byte) (t *TemplateUpdater, err error) {
+	d := decorator.NewDecoratorWithImports(token.NewFileSet(), "template", goast.New())
+
+	file, err := d.Parse(src)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse template")
+	}
+
+	t = &TemplateUpdater{hints: make(map[string]string)}
+
+	for _, imp := range file.Imports {
+		path := mustUnquote(imp.Path.Value)
+
+		name, err := guess.New().ResolvePackage(path)
+		if imp.Name != nil {
+			name, err = imp.Name.Name, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to resolve template import %q", path)
+		}
+
+		t.hints[path] = name
+	}
+
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*dst.FuncDecl)
+		if !ok || fn.Body == nil {
+			continue
+		}
+
+		params := fn.Type.Params.List
+		if len(params) == 0 || len(params[0].Names) != 1 || params[0].Names[0].Name != PlaceholderCtx {
+			return nil, fmt.Errorf("template function %s must have %s as its first parameter", fn.Name.Name, PlaceholderCtx)
+		}
+
+		t.param = params[0].Type
+		t.stmts = fn.Body.List
+		break
+	}
+
+	if t.stmts == nil {
+		return nil, errors.New("template must contain a function with a non-empty body")
+	}
+
+	seen := make(map[string]struct{})
+
+	for _, stmt := range t.stmts {
+		dst.Inspect(stmt, func(n dst.Node) bool {
+			if ident, ok := n.(*dst.Ident); ok && ident.Path != "" {
+				if _, ok := seen[ident.Path]; !ok {
+					seen[ident.Path] = struct{}{}
+					t.imports = append(t.imports, ident.Path)
+				}
+			}
+
+			return true
+		})
+
+		t.patterns = append(t.patterns, dumpLines(substitute(stmt, wildcard, wildcard, nil)))
+	}
+
+	return t, nil
+}
+
+// Hints returns the package names of the template's imports, keyed by import
+// path, for use with NewSimpleResolver.
+func (t *TemplateUpdater) Hints() map[string]string {
+	return t.hints
+}
+
+// Update inserts the template's statements at the start of fn, replacing any
+// existing copies, or removes them if fn should be skipped.
+func (t *TemplateUpdater) Update(fc *FuncContext) (imports []string, err error) {
+	fn := fc.Func
+	if fn.Body == nil || !t.eligible(fn) {
+		return nil, nil
+	}
+
+	ctx, recv, ok := t.names(fn)
+	if !ok {
+		fc.Warnf("template requires named context and receiver parameters")
+		return nil, nil
+	}
+
+	matched := t.match(fn)
+
+	// Remove any existing statements, keeping their comments so they can be
+	// carried over to the regenerated statements.
+	var rest []dst.Stmt
+	comments := make([]dst.NodeDecs, len(t.stmts))
+
+	for i, stmt := range fn.Body.List {
+		owned := -1
+		for j, m := range matched {
+			if m == i {
+				owned = j
+			}
+		}
+
+		if owned < 0 {
+			rest = append(rest, stmt)
+			continue
+		}
+
+		comments[owned] = *stmt.Decorations()
+	}
+
+	if fc.ShouldSkip {
+		if len(rest) > 0 && len(rest) < len(fn.Body.List) {
+			rest[0].Decorations().Before = dst.NewLine
+		}
+
+		fn.Body.List = rest
+		return nil, nil
+	}
+
+	values := templateValues(fn)
+
+	stmts := make([]dst.Stmt, len(t.stmts))
+	for i, stmt := range t.stmts {
+		stmts[i] = substitute(stmt, ctx, recv, values)
+
+		decs := stmts[i].Decorations()
+		decs.Before, decs.After = dst.NewLine, dst.NewLine
+		decs.Start = append(decs.Start, comments[i].Start...)
+		decs.End = append(decs.End, comments[i].End...)
+	}
+
+	// Separate the generated code from the rest of the body
+	if len(rest) > 0 {
+		stmts[len(stmts)-1].Decorations().After = dst.EmptyLine
+		rest[0].Decorations().Before = dst.NewLine
+	}
+
+	fn.Body.List = append(stmts, rest...)
+
+	return t.imports, nil
+}
+
+// Inspect reports whether fn is eligible for the template's statements, and
+// whether they're present and up to date. It can be used as an Inspector.
+func (t *TemplateUpdater) Inspect(fn *dst.FuncDecl) (state FuncState) {
+	if fn.Body == nil || !t.eligible(fn) {
+		return state
+	}
+
+	ctx, recv, ok := t.names(fn)
+	if !ok {
+		return state
+	}
+
+	state.Eligible = true
+	state.SpanName = funcName(fn)
+	state.Present = true
+
+	values := templateValues(fn)
+
+	for i, m := range t.match(fn) {
+		if m < 0 {
+			state.Present = false
+			continue
+		}
+
+		expected := dumpLines(substitute(t.stmts[i], ctx, recv, values))
+		if !reflect.DeepEqual(dumpLines(fn.Body.List[m]), expected) {
+			state.Stale = true
+		}
+	}
+
+	if !state.Present {
+		state.Stale = false
+	}
+
+	return state
+}
+
+// eligible reports whether fn's first parameter has the template's type.
+func (t *TemplateUpdater) eligible(fn *dst.FuncDecl) bool {
+	params := fn.Type.Params.List
+	if len(params) == 0 {
+		return false
+	}
+
+	return reflect.DeepEqual(dumpLines(params[0].Type), dumpLines(t.param))
+}
+
+// names returns the names of fn's context parameter and receiver, if the
+// template needs them.
+func (t *TemplateUpdater) names(fn *dst.FuncDecl) (ctx, recv string, ok bool) {
+	if names := fn.Type.Params.List[0].Names; len(names) > 0 && names[0].Name != "_" {
+		ctx = names[0].Name
+	}
+
+	if fn.Recv != nil && len(fn.Recv.List) > 0 {
+		if names := fn.Recv.List[0].Names; len(names) > 0 && names[0].Name != "_" {
+			recv = names[0].Name
+		}
+	}
+
+	needsCtx, needsRecv := false, false
+	for _, stmt := range t.stmts {
+		dst.Inspect(stmt, func(n dst.Node) bool {
+			if ident, ok := n.(*dst.Ident); ok && ident.Path == "" {
+				needsCtx = needsCtx || ident.Name == PlaceholderCtx
+				needsRecv = needsRecv || ident.Name == PlaceholderRecv
+			}
+
+			return true
+		})
+	}
+
+	ok = (!needsCtx || ctx != "") && (!needsRecv || recv != "")
+
+	return ctx, recv, ok
+}
+
+// match returns the index within fn's body of each of the template's
+// statements, or -1 for those that aren't present.
+func (t *TemplateUpdater) match(fn *dst.FuncDecl) (matched []int) {
+	matched = make([]int, len(t.stmts))
+	taken := make(map[int]bool)
+
+	for i, pattern := range t.patterns {
+		matched[i] = -1
+
+		for j, stmt := range fn.Body.List {
+			if !taken[j] && matchLines(pattern, dumpLines(stmt)) {
+				matched[i] = j
+				taken[j] = true
+				break
+			}
+		}
+	}
+
+	return matched
+}
+
+// templateValues returns the values of the string placeholders for fn.
+func templateValues(fn *dst.FuncDecl) map[string]string {
+	return map[string]string{
+		PlaceholderSpan: funcName(fn),
+		PlaceholderFunc: fn.Name.Name,
+		PlaceholderType: receiverName(fn),
+	}
+}
+
+// substitute returns a copy of stmt with its placeholders replaced. If values
+// is nil, string literals containing placeholders are replaced with wildcards.
+func substitute(stmt dst.Stmt, ctx, recv string, values map[string]string) dst.Stmt {
+	stmt = dst.Clone(stmt).(dst.Stmt)
+
+	dst.Inspect(stmt, func(n dst.Node) bool {
+		switch n := n.(type) {
+		case *dst.Ident:
+			if n.Path != "" {
+				break
+			}
+
+			switch n.Name {
+			case PlaceholderCtx:
+				n.Name = ctx
+			case PlaceholderRecv:
+				n.Name = recv
+			}
+		case *dst.BasicLit:
+			if n.Kind != token.STRING || !strings.Contains(n.Value, "__") {
+				break
+			}
+
+			s, err := strconv.Unquote(n.Value)
+			if err != nil {
+				break
+			}
+
+			for _, placeholder := range []string{PlaceholderSpan, PlaceholderFunc, PlaceholderType} {
+				if !strings.Contains(s, placeholder) {
+					continue
+				}
+
+				if values == nil {
+					s = wildcard
+					break
+				}
+
+				s = strings.ReplaceAll(s, placeholder, values[placeholder])
+			}
+
+			n.Value = strconv.Quote(s)
+		}
+
+		return true
+	})
+
+	return stmt
+}
+
+// dumpLines returns a dump of node's tree, excluding decorations.
+func dumpLines(node dst.Node) []string {
+	buf := &bytes.Buffer{}
+
+	filter := func(name string, value reflect.Value) bool {
+		return name != "Decs" && fingerprintFilter(name, value)
+	}
+
+	if err := dst.Fprint(buf, node, filter); err != nil {
+		panic(err)
+	}
+
+	return strings.Split(buf.String(), "\n")
+}
+
+// matchLines reports whether the dump lines match the pattern, where any line
+// of the pattern containing a wildcard matches any line with the same field
+// name, i.e. the same text up to the first quote.
+func matchLines(pattern, lines []string) bool {
+	if len(pattern) != len(lines) {
+		return false
+	}
+
+	for i := range pattern {
+		if pattern[i] == lines[i] {
+			continue
+		}
+
+		if !strings.Contains(pattern[i], wildcard) {
+			return false
+		}
+
+		field := strings.SplitN(pattern[i], `"`, 2)[0]
+		if strings.SplitN(lines[i], `"`, 2)[0] != field {
+			return false
+		}
+	}
+
+	return true
+}
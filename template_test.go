@@ -0,0 +1,144 @@
+package tracegen
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver"
+)
+
+const testTemplate = `package template
+
+func _(__ctx__ int) {
+	println(__ctx__, "__span__")
+	defer println("__func__ done")
+}
+`
+
+const templateInput = `package main
+
+type T struct{}
+
+func Foo(ctx int) {
+	println("body")
+}
+
+func (t T) Bar(ctx int) {
+	println(ctx, "Old")
+	defer println("Old done")
+
+	println("body")
+}
+
+//trace:skip
+func Baz(ctx int) {
+	println(ctx, "Baz")
+	defer println("Baz done")
+
+	println("body")
+}
+
+func Qux(s string) {
+	println(s, "Qux")
+}
+`
+
+const templateOutput = `package main
+
+type T struct{}
+
+func Foo(ctx int) {
+	println(ctx, "Foo")
+	defer println("Foo done")
+
+	println("body")
+}
+
+func (t T) Bar(ctx int) {
+	println(ctx, "T.Bar")
+	defer println("Bar done")
+
+	println("body")
+}
+
+//trace:skip
+func Baz(ctx int) {
+	println("body")
+}
+
+func Qux(s string) {
+	println(s, "Qux")
+}
+`
+
+func TestTemplateUpdater(t *testing.T) {
+	update, err := NewTemplateUpdater([]byte(testTemplate))
+	check(t, err)
+
+	path := writeModule(t, templateInput)
+	err = os.Chdir(filepath.Dir(path))
+	check(t, err)
+
+	getResolver := func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+		return NewSimpleResolver(pkg, file, update.Hints())
+	}
+
+	// Running twice checks the existing statements are recognised
+	for i := 0; i < 2; i++ {
+		_, err = Process(Settings{}, []string{"."}, update, getResolver)
+		check(t, err)
+
+		data, err := os.ReadFile(path)
+		check(t, err)
+
+		if string(data) != templateOutput {
+			t.Fatalf("mismatched output on run %d:\ngot:\n%s\nexpected:\n%s", i+1, string(data), templateOutput)
+		}
+	}
+}
+
+func TestTemplateUpdaterInspect(t *testing.T) {
+	update, err := NewTemplateUpdater([]byte(testTemplate))
+	check(t, err)
+
+	file, err := decorator.Parse(templateInput)
+	check(t, err)
+
+	expected := map[string]FuncState{
+		"Foo": {Eligible: true, SpanName: "Foo"},
+		"Bar": {Eligible: true, Present: true, Stale: true, SpanName: "T.Bar"},
+		"Baz": {Eligible: true, Present: true, SpanName: "Baz"},
+		"Qux": {},
+	}
+
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*dst.FuncDecl)
+		if !ok {
+			continue
+		}
+
+		if state := update.Inspect(fn); state != expected[fn.Name.Name] {
+			t.Errorf("mismatched state for %s, got %+v, expected %+v", fn.Name.Name, state, expected[fn.Name.Name])
+		}
+	}
+}
+
+func TestNewTemplateUpdaterErrors(t *testing.T) {
+	tests := map[string]string{
+		"invalid syntax":    "package template\n\nfunc _(",
+		"no function":       "package template\n",
+		"missing ctx param": "package template\n\nfunc _(ctx int) {\n\tprintln(ctx)\n}\n",
+		"no params":         "package template\n\nfunc _() {\n\tprintln()\n}\n",
+	}
+
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewTemplateUpdater([]byte(src)); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package tracegen
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// An Updater injects its code into, or removes its code from, the functions
+// it's invoked on.
+//
+// Correct, idempotent behaviour of tracegen is entirely dependent on the
+// updater. Generally speaking, an updater should inject its code when
+// fc.ShouldSkip is false, remove it (if present) when fc.ShouldSkip is true,
+// and return all imports needed by the inserted code.
+//
+// An updater that can't safely rewrite a function should return an error,
+// which aborts the run before any files are written. Less serious problems
+// can be reported via fc.Warnf.
+//
+// Updaters used by a Processor with Jobs greater than one must be safe for
+// concurrent calls on functions in different packages.
+type Updater interface {
+	Update(fc *FuncContext) (imports []string, err error)
+}
+
+// UpdateFunc adapts an ordinary function to the Updater interface.
+type UpdateFunc func(fn *dst.FuncDecl, shouldSkip bool) (imports []string)
+
+func (f UpdateFunc) Update(fc *FuncContext) (imports []string, err error) {
+	return f(fc.Func, fc.ShouldSkip), nil
+}
+
+// UpdateError is returned when an updater fails on a function.
+type UpdateError struct {
+	Pos  token.Position
+	Func string
+	Err  error
+}
+
+func (e *UpdateError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %v", e.Pos.Filename, e.Pos.Line, e.Pos.Column, e.Func, e.Err)
+}
+
+func (e *UpdateError) Unwrap() error {
+	return e.Err
+}
+
+// Cause allows github.com/pkg/errors to find the underlying error.
+func (e *UpdateError) Cause() error {
+	return e.Err
+}
+
+// FuncContext describes a function being visited by an Updater, along with
+// the decision tracegen has made about it.
+type FuncContext struct {
+	Func *dst.FuncDecl
+	File *dst.File
+
+	// Filename is the path of the file containing Func.
+	Filename string
+
+	Package *decorator.Package
+
+	// PkgPath is the import path of Package.
+	PkgPath string
+
+	// Receiver is the name of the receiver's type, or an empty string if Func
+	// is not a method.
+	Receiver string
+
+	// TypesInfo holds the type information for Package, and is nil if the
+	// package was loaded without types.
+	TypesInfo *types.Info
+
+	// Directives lists the trace directives (e.g. "trace:enable") in Func's
+	// doc comment.
+	Directives []string
+
+	// ShouldSkip is true if the updater should remove its code, and
+	// SkipReason explains why.
+	ShouldSkip bool
+	SkipReason string
+
+	warnings []string
+}
+
+// Warnf records a warning about the function, which is reported in the
+// run's result.
+func (fc *FuncContext) Warnf(format string, args ...interface{}) {
+	fc.warnings = append(fc.warnings, fmt.Sprintf(format, args...))
+}
+
+// Position returns the position of the function within its file.
+func (fc *FuncContext) Position() token.Position {
+	start, _ := position(fc.Package, fc.Func)
+	return start
+}
+
+func newFuncContext(pkg *decorator.Package, file *dst.File, fn *dst.FuncDecl, d *decider) *FuncContext {
+	fc := &FuncContext{
+		Func:       fn,
+		File:       file,
+		Filename:   pkg.Decorator.Filenames[file],
+		Package:    pkg,
+		PkgPath:    pkg.PkgPath,
+		Receiver:   receiverName(fn),
+		TypesInfo:  pkg.TypesInfo,
+		Directives: directives(fn.Decs.Start),
+	}
+
+	fc.ShouldSkip, fc.SkipReason = d.decide(fn)
+
+	return fc
+}
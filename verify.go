@@ -0,0 +1,160 @@
+package tracegen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"strconv"
+
+	"github.com/dave/dst/decorator"
+	"github.com/pkg/errors"
+	gopackages "golang.org/x/tools/go/packages"
+)
+
+// VerifyError is returned when a package fails to type-check once rewritten.
+// Func is the function containing the error, if any.
+type VerifyError struct {
+	Pos  token.Position
+	Func string
+	Err  error
+}
+
+func (e *VerifyError) Error() string {
+	if e.Func == "" {
+		return fmt.Sprintf("%s:%d:%d: rewritten code does not compile: %v", e.Pos.Filename, e.Pos.Line, e.Pos.Column, e.Err)
+	}
+
+	return fmt.Sprintf("%s:%d:%d: %s: rewritten code does not compile: %v", e.Pos.Filename, e.Pos.Line, e.Pos.Column, e.Func, e.Err)
+}
+
+func (e *VerifyError) Unwrap() error {
+	return e.Err
+}
+
+// Cause allows github.com/pkg/errors to find the underlying error.
+func (e *VerifyError) Cause() error {
+	return e.Err
+}
+
+// verify type-checks the package described by result with the changed files
+// in place of those in the processor's FS, returning a VerifyError for the
+// first error within a changed file. If the package already had errors in
+// those files, a warning is added to result instead, as the rewrite can't be
+// blamed for them.
+func (p *Processor) verify(result *PackageResult, changed []fileChange) error {
+	overlay := p.fs().Overlay()
+	if overlay == nil {
+		overlay = make(map[string][]byte)
+	}
+
+	original := make(map[string][]byte, len(overlay))
+	for filename, data := range overlay {
+		original[filename] = data
+	}
+
+	files := make(map[string][]byte)
+	for _, f := range changed {
+		// Removed files can't be overlaid, and are rarely the cause
+		if !f.remove {
+			overlay[f.filename] = f.data
+			files[f.filename] = f.data
+		}
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+
+	pos, msg, err := p.typeCheck(result, overlay, files)
+	if err != nil || msg == "" {
+		return err
+	}
+
+	if _, previous, err := p.typeCheck(result, original, files); err != nil {
+		return err
+	} else if previous != "" {
+		f := newFinding(RuleUnverified, fmt.Sprintf("could not verify the rewritten package, as it already failed to type-check: %s", previous), pos)
+		f.Level = LevelWarning
+
+		result.Warnings = append(result.Warnings, f)
+
+		return nil
+	}
+
+	return &VerifyError{Pos: pos, Func: enclosingFunc(pos, files[pos.Filename]), Err: errors.New(msg)}
+}
+
+// typeCheck loads the package described by result using overlay, returning the
+// position and message of its first error within files, if any.
+func (p *Processor) typeCheck(result *PackageResult, overlay map[string][]byte, files map[string][]byte) (pos token.Position, msg string, err error) {
+	cfg := p.config(typesMode)
+	cfg.Overlay = overlay
+
+	pattern := result.Path
+	if pattern == "command-line-arguments" {
+		pattern = result.Dir
+	}
+
+	pkgs, err := gopackages.Load(cfg, pattern)
+	if err != nil {
+		return pos, "", errors.Wrapf(err, "failed to load package %s for verification", result.Path)
+	}
+
+	for _, pkg := range pkgs {
+		for _, e := range pkg.Errors {
+			pos, ok := parseErrorPos(e.Pos)
+			if !ok {
+				continue
+			}
+
+			if _, ok := files[pos.Filename]; ok {
+				return pos, e.Msg, nil
+			}
+		}
+	}
+
+	return pos, "", nil
+}
+
+var errorPosPattern = regexp.MustCompile(`^(.+?):(\d+)(?::(\d+))?$`)
+
+// parseErrorPos parses the position of a go/packages error.
+func parseErrorPos(s string) (pos token.Position, ok bool) {
+	m := errorPosPattern.FindStringSubmatch(s)
+	if m == nil {
+		return pos, false
+	}
+
+	pos.Filename = m[1]
+	pos.Line, _ = strconv.Atoi(m[2])
+	pos.Column, _ = strconv.Atoi(m[3])
+
+	return pos, true
+}
+
+// enclosingFunc returns the name of the function in src containing pos, or an
+// empty string if there is none.
+func enclosingFunc(pos token.Position, src []byte) string {
+	fset := token.NewFileSet()
+	d := decorator.NewDecorator(fset)
+
+	file, err := d.ParseFile(pos.Filename, src, parser.ParseComments)
+	if err != nil {
+		return ""
+	}
+
+	for _, fn := range funcDecls(file.Decls) {
+		n, ok := d.Ast.Nodes[fn].(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+
+		if fset.Position(n.Pos()).Line <= pos.Line && pos.Line <= fset.Position(n.End()).Line {
+			return funcName(fn)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,107 @@
+package tracegen
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver"
+)
+
+const verifyInput = `package main
+
+func A() {}
+
+type T struct{}
+
+func (t *T) B() {}
+`
+
+const verifyStdInput = `package main
+
+import "strings"
+
+func A() string {
+	return strings.ToUpper("a")
+}
+
+type T struct{}
+
+func (t *T) B() {}
+`
+
+// callUpdater inserts a call to the function with the given name into each
+// method.
+type callUpdater string
+
+func (u callUpdater) Update(fc *FuncContext) (imports []string, err error) {
+	if fc.Receiver != "" && len(fc.Func.Body.List) == 0 {
+		fc.Func.Body.List = append(fc.Func.Body.List, &dst.ExprStmt{
+			X:    &dst.CallExpr{Fun: dst.NewIdent(string(u))},
+			Decs: dst.ExprStmtDecorations{NodeDecs: dst.NodeDecs{Before: dst.NewLine, After: dst.NewLine}},
+		})
+	}
+
+	return nil, nil
+}
+
+func TestProcessorVerify(t *testing.T) {
+	tests := map[string]struct {
+		input    string
+		call     string
+		expected string
+
+		// The line the error is expected on, if any
+		line int
+	}{
+		"compiles":         {verifyInput, "A", strings.Replace(verifyInput, "B() {}", "B() {\n\tA()\n}", 1), 0},
+		"does not compile": {verifyInput, "undefined", verifyInput, 8},
+
+		// Packages with imports are type-checked against their dependencies
+		"imports compiles":         {verifyStdInput, "A", strings.Replace(verifyStdInput, "B() {}", "B() {\n\tA()\n}", 1), 0},
+		"imports does not compile": {verifyStdInput, "undefined", verifyStdInput, 12},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeModule(t, test.input)
+
+			p := &Processor{
+				Updater: callUpdater(test.call),
+				GetResolver: func(pkg *decorator.Package, file *dst.File) resolver.RestorerResolver {
+					return NewSimpleResolver(pkg, file, nil)
+				},
+				Dir:    filepath.Dir(path),
+				Verify: true,
+			}
+
+			_, err := p.Process([]string{"."})
+
+			fails := test.line != 0
+
+			var verifyErr *VerifyError
+			if errors.As(err, &verifyErr) != fails {
+				t.Fatalf("expected a verification failure: %t, got %v", fails, err)
+			}
+
+			if fails && (verifyErr.Func != "T.B" || verifyErr.Pos.Line != test.line) {
+				t.Fatalf("expected the error to be reported in T.B on line %d, got %v", test.line, verifyErr)
+			}
+
+			if !fails {
+				check(t, err)
+			}
+
+			data, err := os.ReadFile(path)
+			check(t, err)
+
+			if string(data) != test.expected {
+				t.Fatalf("mismatched output:\ngot:\n%s\nexpected:\n%s", string(data), test.expected)
+			}
+		})
+	}
+}